@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/dbutil"
+	"github.com/binaryholdings/cosmos-pruner/pkg/pruner"
+)
+
+var (
+	analyzeStore string
+	topN         int
+	prefixLen    int
+	output       string
+)
+
+type analysis struct {
+	Stores   []pruner.StoreUsage  `json:"stores"`
+	Store    string               `json:"store,omitempty"`
+	Version  int64                `json:"version,omitempty"`
+	Prefixes []pruner.PrefixUsage `json:"prefixes,omitempty"`
+}
+
+func analyzeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "analyze",
+		Short: "report the disk usage of every store in the application db",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if output != "table" && output != "json" {
+				return fmt.Errorf("invalid output format %q, expected table or json", output)
+			}
+			if topN < 0 {
+				return fmt.Errorf("top must not be negative")
+			}
+			if prefixLen < 1 {
+				return fmt.Errorf("prefix-len must be at least 1")
+			}
+
+			p, err := newReportPruner()
+			if err != nil {
+				return err
+			}
+
+			a := analysis{}
+			if a.Stores, err = p.StoreUsages(cmd.Context()); err != nil {
+				return err
+			}
+
+			if analyzeStore != "" {
+				a.Store = analyzeStore
+				if a.Version, a.Prefixes, err = p.PrefixUsages(cmd.Context(), analyzeStore, topN, prefixLen); err != nil {
+					return err
+				}
+			}
+
+			if output == "json" {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(a)
+			}
+
+			return printAnalysis(a)
+		},
+	}
+
+	// --store flag
+	cmd.Flags().StringVar(&analyzeStore, "store", "", "report the largest key prefixes in the latest tree of this store")
+	// --top flag
+	cmd.Flags().IntVar(&topN, "top", 10, "set the amount of key prefixes to be reported")
+	// --prefix-len flag
+	cmd.Flags().IntVar(&prefixLen, "prefix-len", 1, "set the length in bytes of the key prefixes")
+	// --output flag
+	cmd.Flags().StringVar(&output, "output", "table", "set the output format (table|json)")
+
+	return cmd
+}
+
+func printAnalysis(a analysis) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	var total, disk int64
+	fmt.Fprintln(w, "STORE\tDISK\tNODES\tNODE KEYS\tORPHANS\tORPHAN KEYS\tROOTS\tROOT KEYS\tTOTAL")
+	for _, s := range a.Stores {
+		t := s.Total()
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%d\t%s\t%d\t%s\n", s.Name, dbutil.FormatBytes(s.Disk),
+			dbutil.FormatBytes(s.Nodes.Bytes), s.Nodes.Keys, dbutil.FormatBytes(s.Orphans.Bytes), s.Orphans.Keys,
+			dbutil.FormatBytes(s.Roots.Bytes), s.Roots.Keys, dbutil.FormatBytes(t.Bytes))
+		total += t.Bytes
+		disk += s.Disk
+	}
+	fmt.Fprintf(w, "total\t%s\t\t\t\t\t\t\t%s\n", dbutil.FormatBytes(disk), dbutil.FormatBytes(total))
+
+	if a.Store != "" {
+		fmt.Fprintf(w, "\nPREFIX (%s@%d)\tKEYS\tBYTES\n", a.Store, a.Version)
+		for _, p := range a.Prefixes {
+			fmt.Fprintf(w, "%s\t%d\t%s\n", p.Prefix, p.Keys, dbutil.FormatBytes(p.Bytes))
+		}
+	}
+
+	return w.Flush()
+}
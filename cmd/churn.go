@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/dbutil"
+	"github.com/binaryholdings/cosmos-pruner/pkg/pruner"
+)
+
+var (
+	fromVersion int64
+	toVersion   int64
+	interval    int64
+	churnStores []string
+)
+
+func churnCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "churn",
+		Short: "report the nodes orphaned per version in every store, without modifying any data",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if output != "table" && output != "json" {
+				return fmt.Errorf("invalid output format %q, expected table or json", output)
+			}
+
+			p, err := newReportPruner()
+			if err != nil {
+				return err
+			}
+
+			churns, err := p.Churn(cmd.Context(), churnStores, fromVersion, toVersion, interval)
+			if err != nil {
+				return err
+			}
+
+			if output == "json" {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(churns)
+			}
+
+			return printChurn(churns)
+		},
+	}
+
+	// --from flag
+	cmd.Flags().Int64Var(&fromVersion, "from", 1, "set the first version to be reported")
+	// --to flag
+	cmd.Flags().Int64Var(&toVersion, "to", 0, "set the last version to be reported (0=latest)")
+	// --interval flag
+	cmd.Flags().Int64Var(&interval, "interval", 1000, "set the amount of versions summed in one point of the series")
+	// --stores flag
+	cmd.Flags().StringSliceVar(&churnStores, "stores", []string{}, "stores to be reported in format: \"store_name,store_name\" (default all)")
+	// --output flag
+	cmd.Flags().StringVar(&output, "output", "table", "set the output format (table|json)")
+
+	return cmd
+}
+
+// printChurn prints the totals per store, then the orphaned bytes of every store over time
+func printChurn(churns []pruner.StoreChurn) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "STORE\tORPHANED NODES\tORPHANED BYTES")
+	for _, c := range churns {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", c.Name, c.Total.Keys, dbutil.FormatBytes(c.Total.Bytes))
+	}
+	fmt.Fprintln(w)
+
+	buckets := make(map[int64]map[string]int64)
+	for _, c := range churns {
+		for _, p := range c.Series {
+			if buckets[p.Version] == nil {
+				buckets[p.Version] = make(map[string]int64)
+			}
+			buckets[p.Version][c.Name] = p.Bytes
+		}
+	}
+	versions := make([]int64, 0, len(buckets))
+	for v := range buckets {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+
+	fmt.Fprint(w, "VERSION")
+	for _, c := range churns {
+		fmt.Fprintf(w, "\t%s", c.Name)
+	}
+	fmt.Fprintln(w)
+	for _, v := range versions {
+		fmt.Fprintf(w, "%d", v)
+		for _, c := range churns {
+			fmt.Fprintf(w, "\t%s", dbutil.FormatBytes(buckets[v][c.Name]))
+		}
+		fmt.Fprintln(w)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/binaryholdings/cosmos-pruner/pkg/pruner"
+)
+
+var (
+	outDir string
+)
+
+func compactCmd() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "compact",
+		Short: "compact data from the application store and block store",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, err := newPruner()
+			if err != nil {
+				return err
+			}
+
+			ctx, stop := notifyContext(cmd.Context())
+			defer stop()
+
+			_, err = p.Compact(ctx)
+			var spaceErr *pruner.SpaceError
+			if errors.As(err, &spaceErr) {
+				return fmt.Errorf("%w, free up space or use --out-dir to write to another disk", err)
+			}
+			if err != nil && err != ctx.Err() {
+				return err
+			}
+			if ctx.Err() != nil {
+				return interruptedError()
+			}
+
+			return nil
+		},
+	}
+
+	// --out-dir flag
+	cmd.Flags().StringVar(&outDir, "out-dir", "", "rewrite the dbs into this directory, then move them into place (\"\"=compact in place)")
+
+	return cmd
+}
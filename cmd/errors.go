@@ -0,0 +1,24 @@
+package cmd
+
+const (
+	// exitFailure is the exit code of a command that could not run
+	exitFailure = 1
+	// exitPartial is the exit code of a command that ran, but failed for some of the stores
+	exitPartial = 2
+	// exitInterrupted is the exit code of a command that stopped on SIGINT or SIGTERM
+	exitInterrupted = 130
+)
+
+// exitError is an error that exits the process with a specific code
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
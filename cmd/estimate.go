@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	samples uint64
+)
+
+func estimateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "estimate",
+		Short: "estimate the disk space reclaimed by pruning without modifying any data",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if samples < 1 {
+				return fmt.Errorf("samples must be at least 1")
+			}
+			if _, err := resolveSettings(cmd); err != nil {
+				return err
+			}
+			p, err := newPruner()
+			if err != nil {
+				return err
+			}
+
+			estimate, err := p.Estimate(cmd.Context(), int(samples))
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+			if cosmosSdk {
+				var appBytes, sampled int64
+				fmt.Fprintln(w, "STORE\tVERSIONS\tPRUNED\tNODES\tORPHANS\tROOTS\tTOTAL")
+				for _, e := range estimate.Stores {
+					fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\t%s\n", e.Name, e.Versions, e.Pruned,
+						formatGB(e.NodeBytes), formatGB(e.OrphanBytes), formatGB(e.RootBytes), formatGB(e.Bytes()))
+					appBytes += e.Bytes()
+					sampled += e.Sampled
+				}
+				fmt.Fprintf(w, "application\t\t\t\t\t\t%s\n", formatGB(appBytes))
+				fmt.Fprintf(w, "nodes extrapolated from %d orphans sampled across the pruned versions\n\n", sampled)
+			}
+
+			if tm := estimate.Tendermint; tm != nil {
+				fmt.Fprintln(w, "DB\tTOTAL")
+				fmt.Fprintf(w, "blockstore\t%s\n", formatGB(tm.BlockBytes))
+				fmt.Fprintf(w, "state\t%s\n\n", formatGB(tm.StateBytes))
+			}
+
+			if err := w.Flush(); err != nil {
+				return err
+			}
+
+			fmt.Printf("extrapolated reclaimed space: %s\n", formatGB(estimate.Bytes()))
+			fmt.Printf("extrapolated deleted keys: %d (measured %.0f deletes/s)\n", estimate.Keys(), estimate.DeleteRate)
+			fmt.Printf("extrapolated pruning time: %s\n", estimate.Duration().Round(time.Second))
+
+			return nil
+		},
+	}
+
+	// --samples flag
+	cmd.Flags().Uint64Var(&samples, "samples", 1000, "set the amount of orphans and blocks to be sampled per store")
+
+	return cmd
+}
+
+// formatGB prints a byte count in GB
+func formatGB(b int64) string {
+	return fmt.Sprintf("%.3f GB", float64(b)/1e9)
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+func explainCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "explain",
+		Short: "print the effective pruning settings, where each of them comes from and what prune would refuse",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			res, err := resolveSettings(cmd)
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "SETTING\tVALUE\tFROM\tOVERRIDES")
+			for _, s := range res.settings {
+				overrides := make([]string, 0, len(s.candidates)-1)
+				for _, c := range s.candidates[1:] {
+					overrides = append(overrides, c.String())
+				}
+				c := s.effective()
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.name, c.value, c.from(), strings.Join(overrides, ", "))
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+
+			if res.profile != nil && len(res.profile.stores) > 0 {
+				stores := storeRetention(res.profile.stores, keepVersions, keepEvery)
+				names := make([]string, 0, len(stores))
+				for name := range stores {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+
+				fmt.Printf("\nstores overridden by profile %s:\n", res.profile.Name)
+				fmt.Fprintln(w, "STORE\tKEEP-RECENT\tKEEP-EVERY")
+				for _, name := range names {
+					fmt.Fprintf(w, "%s\t%d\t%d\n", name, stores[name].KeepRecent, stores[name].KeepEvery)
+				}
+				if err := w.Flush(); err != nil {
+					return err
+				}
+			}
+
+			if len(res.notes) > 0 {
+				fmt.Println()
+				for _, note := range res.notes {
+					fmt.Println("note:", note)
+				}
+			}
+
+			if warnings := res.warnings(); len(warnings) > 0 {
+				fmt.Println()
+				for _, w := range warnings {
+					fmt.Println("warning:", w)
+				}
+			}
+
+			if conflicts := res.conflicts(); len(conflicts) > 0 {
+				fmt.Println()
+				for _, c := range conflicts {
+					fmt.Println("prune refuses:", c)
+				}
+			}
+
+			return nil
+		},
+	}
+
+	return cmd
+}
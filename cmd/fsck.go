@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	sample     int
+	fsckStores []string
+)
+
+func fsckCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "fsck",
+		Short: "check that the IAVL trees of the application db are complete and valid",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, err := newPruner()
+			if err != nil {
+				return err
+			}
+
+			checks, err := p.Check(cmd.Context(), fsckStores, sample)
+			if err != nil {
+				return err
+			}
+
+			problems := 0
+			for _, check := range checks {
+				fmt.Printf("store %s: checked %d/%d versions, %d nodes, %d problems\n",
+					check.Name, check.Checked, check.Versions, check.Nodes, len(check.Problems))
+				for _, p := range check.Problems {
+					fmt.Printf("  %s\n", p)
+				}
+				problems += len(check.Problems)
+			}
+
+			if problems > 0 {
+				return fmt.Errorf("found %d problems", problems)
+			}
+			fmt.Println("no problems found")
+
+			return nil
+		},
+	}
+
+	// --sample flag
+	cmd.Flags().IntVar(&sample, "sample", 0, "set the amount of versions to be checked per store, spread evenly and including the latest (0=all)")
+	// --stores flag
+	cmd.Flags().StringSliceVar(&fsckStores, "stores", []string{}, "stores to be checked in format: \"store_name,store_name\" (default all)")
+
+	return cmd
+}
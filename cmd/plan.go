@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/binaryholdings/cosmos-pruner/pkg/pruner"
+)
+
+var (
+	planFile string
+	// pruningPlan is the plan applied by newPruner's pruner, if set
+	pruningPlan *pruner.Plan
+)
+
+func planCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "plan",
+		Short: "write the versions and heights prune would delete as a plan to be reviewed and applied, without modifying any data",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			res, err := resolveSettings(cmd)
+			if err != nil {
+				return err
+			}
+			if conflicts := res.conflicts(); len(conflicts) > 0 {
+				return fmt.Errorf("refusing to plan, see %s explain: %s", appName, strings.Join(conflicts, "; "))
+			}
+			printWarnings(res)
+			p, err := newPruner()
+			if err != nil {
+				return err
+			}
+
+			plan, err := p.Plan(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			if planFile == "" {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(plan)
+			}
+			if err := plan.WriteFile(planFile); err != nil {
+				return err
+			}
+
+			fmt.Println("wrote plan:", planFile)
+			return printPlan(plan)
+		},
+	}
+
+	// --output flag
+	cmd.Flags().StringVarP(&planFile, "output", "o", "", "write the plan to this file instead of stdout")
+
+	return cmd
+}
+
+func applyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "apply <plan.json>",
+		Short: "delete exactly the versions and heights of a plan, refusing if the data changed since it was made",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			plan, err := pruner.ReadPlanFile(args[0])
+			if err != nil {
+				return err
+			}
+
+			pruningPlan = plan
+			p, err := newPruner()
+			if err != nil {
+				return err
+			}
+
+			var mismatch *pruner.PlanMismatchError
+			if err := p.CheckPlan(cmd.Context(), plan); errors.As(err, &mismatch) {
+				return fmt.Errorf("refusing to apply plan %s, make a new plan: %w", args[0], err)
+			} else if err != nil {
+				return err
+			}
+
+			fmt.Println("plan:", args[0])
+			fmt.Println("created:", plan.Created)
+			fmt.Println("batch:", batch)
+			fmt.Println("parallel-limit:", parallel)
+			fmt.Println("engine:", engine)
+			if err := printPlan(plan); err != nil {
+				return err
+			}
+
+			return runPrune(cmd, p, plan.Stores != nil, plan.Tendermint != nil, "make and apply a new plan to continue")
+		},
+	}
+
+	return cmd
+}
+
+// printPlan prints what a plan deletes
+func printPlan(plan *pruner.Plan) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	if plan.Upgrades != nil {
+		fmt.Fprintf(w, "protected upgrades: %s\n", upgradesString(plan.Upgrades, plan.UpgradeWindow))
+	}
+	if len(plan.Snapshots) > 0 || plan.SnapshotInterval > 0 {
+		fmt.Fprintf(w, "protected snapshots: %d local", len(plan.Snapshots))
+		if plan.SnapshotInterval > 0 {
+			fmt.Fprintf(w, ", every %d heights (keep recent %d)", plan.SnapshotInterval, plan.SnapshotKeepRecent)
+		}
+		fmt.Fprintln(w)
+	}
+	if plan.Stores != nil {
+		fmt.Fprintf(w, "latest version: %d\n", plan.Fingerprint.LatestVersion)
+		if len(plan.PruneHeights) > 0 {
+			fmt.Fprintf(w, "prune heights: %s\n", heightRangesString(plan.PruneHeights))
+		}
+		if len(plan.KeepHeights) > 0 {
+			fmt.Fprintf(w, "keep heights: %s\n", heightRangesString(plan.KeepHeights))
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "STORE\tVERSIONS\tDELETE\tRANGES")
+		for _, s := range plan.Stores {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", s.Name, s.Versions, s.Delete, len(s.Heights))
+		}
+		fmt.Fprintln(w)
+	}
+
+	if tm := plan.Tendermint; tm != nil {
+		fmt.Fprintf(w, "blocks: %d-%d", plan.Fingerprint.BlockBase, plan.Fingerprint.BlockHeight)
+		if len(tm.Blocks) > 0 {
+			fmt.Fprintf(w, ", delete blocks %s and states %s", heightRangesString(tm.Blocks), heightRangesString(tm.States))
+		}
+		fmt.Fprintf(w, ", expired evidence below %d\n\n", tm.PruneHeight)
+	}
+
+	fmt.Fprintln(w, "compact:", strings.Join(plan.Compact, ", "))
+
+	return w.Flush()
+}
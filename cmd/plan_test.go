@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cosmos/cosmos-sdk/store/types"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	db "github.com/tendermint/tm-db"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/iavldb"
+	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+)
+
+// commitVersions commits versions of the acc store into the application db of home
+func commitVersions(t *testing.T, home string, versions int) {
+	appDB, err := db.NewGoLevelDB("application", filepath.Join(home, "data"))
+	require.NoError(t, err)
+	defer appDB.Close()
+
+	appStore := rootmulti.NewStore(appDB)
+	key := types.NewKVStoreKey("acc")
+	appStore.MountStoreWithDB(key, types.StoreTypeIAVL, nil)
+	require.NoError(t, appStore.LoadLatestVersion())
+	for v := 0; v < versions; v++ {
+		appStore.GetCommitKVStore(key).Set([]byte{byte(v)}, []byte("acc"))
+		appStore.Commit()
+	}
+}
+
+// runCmd runs the root command with the arguments
+func runCmd(t *testing.T, args ...string) error {
+	viper.Reset()
+	t.Cleanup(func() {
+		viper.Reset()
+		pruningPlan = nil
+	})
+
+	cmd := NewRootCmd()
+	cmd.SilenceUsage = true
+	cmd.SetArgs(args)
+
+	return cmd.Execute()
+}
+
+func TestApplyRefusesChangedData(t *testing.T) {
+	home := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(home, "config"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(home, "config", "app.toml"), []byte("pruning = \"custom\"\n"), 0644))
+	commitVersions(t, home, 5)
+
+	planPath := filepath.Join(t.TempDir(), "plan.json")
+	require.NoError(t, runCmd(t, "prune", "plan", "--home", home, "--tendermint=false",
+		"--pruning-keep-recent=2", "--pruning-keep-every=0", "-o", planPath))
+
+	// the node committed another version after the plan was made
+	commitVersions(t, home, 1)
+
+	err := runCmd(t, "prune", "apply", planPath, "--home", home)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "refusing to apply plan "+planPath)
+	require.Contains(t, err.Error(), "latest version is 6, planned at 5")
+
+	// nothing was pruned
+	appDB, err := db.NewGoLevelDB("application", filepath.Join(home, "data"))
+	require.NoError(t, err)
+	defer appDB.Close()
+	for v := int64(1); v <= 6; v++ {
+		has, err := appDB.Has(append([]byte("s/k:acc/"), iavldb.RootKey(v)...))
+		require.NoError(t, err)
+		require.True(t, has, "version %d", v)
+	}
+}
@@ -0,0 +1,337 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/pelletier/go-toml"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/binaryholdings/cosmos-pruner/pkg/pruner"
+)
+
+var (
+	// prunerConfigFiles are the pruner config files in the home directory, only the first one that
+	// exists is read
+	prunerConfigFiles = []string{"config/pruner.toml", "config/pruner.yaml", "config/pruner.yml"}
+	// prunerConfigFile is the pruner config file that was read, if any
+	prunerConfigFile string
+	// customProfiles are the pruning profiles of the pruner config file by name
+	customProfiles map[string]profileDefinition
+	// profileStores are the store overrides of the selected pruning profile
+	profileStores map[string]storeDefinition
+)
+
+// profileDefinition is a pruning profile of the pruner config file. Its unset values are those of
+// the profile it extends, which is default unless set.
+type profileDefinition struct {
+	Extends      string                     `mapstructure:"extends"`
+	Blocks       *uint64                    `mapstructure:"min-retain-blocks"`
+	KeepVersions *uint64                    `mapstructure:"pruning-keep-recent"`
+	KeepEvery    *uint64                    `mapstructure:"pruning-keep-every"`
+	Stores       map[string]storeDefinition `mapstructure:"stores"`
+}
+
+// storeDefinition overrides the retention of a single store, its unset values are those of the
+// profile
+type storeDefinition struct {
+	KeepVersions *uint64 `mapstructure:"pruning-keep-recent"`
+	KeepEvery    *uint64 `mapstructure:"pruning-keep-every"`
+}
+
+// resolvedProfile is a pruning profile with the profiles it extends applied
+type resolvedProfile struct {
+	pruner.PruningProfile
+	// extends are the profiles it extends, nearest first
+	extends []string
+	stores  map[string]storeDefinition
+	// source is the file the profile is defined in, empty for built-in profiles
+	source string
+}
+
+// loadPrunerConfig registers the app profiles and reads the pruning profiles of the pruner config
+// file in the home directory
+func loadPrunerConfig() error {
+	for _, name := range prunerConfigFiles {
+		path := rootify(name, homePath)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if _, err := pruner.RegisterAppProfilesFile(path); err != nil {
+			return err
+		}
+
+		var err error
+		if customProfiles, err = readProfiles(path); err != nil {
+			return fmt.Errorf("failed to read pruning profiles from %s: %w", path, err)
+		}
+		for name := range customProfiles {
+			if _, err := pruner.LookupPruningProfile(name); err == nil || name == "app" || name == "custom" {
+				return fmt.Errorf("pruning profile %s in %s is built-in, extend it under another name", name, path)
+			}
+		}
+
+		prunerConfigFile = path
+		return nil
+	}
+
+	return nil
+}
+
+// readProfiles decodes the profiles table of a TOML or YAML file by itself, as viper lowercases
+// the names of the profiles and stores
+func readProfiles(path string) (map[string]profileDefinition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config struct {
+		Profiles map[string]interface{} `toml:"profiles" yaml:"profiles"`
+	}
+	if filepath.Ext(path) == ".toml" {
+		err = toml.Unmarshal(data, &config)
+	} else {
+		err = yaml.Unmarshal(data, &config)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := make(map[string]profileDefinition, len(config.Profiles))
+	if err := mapstructure.Decode(config.Profiles, &profiles); err != nil {
+		return nil, err
+	}
+
+	return profiles, nil
+}
+
+// resolvePruningProfile returns the pruning profile of the given name with the profiles it extends
+// applied
+func resolvePruningProfile(name string) (resolvedProfile, error) {
+	return resolveProfile(name, map[string]bool{})
+}
+
+func resolveProfile(name string, seen map[string]bool) (resolvedProfile, error) {
+	if name == "app" {
+		a, err := appProfile()
+		if err != nil {
+			return resolvedProfile{}, err
+		}
+		return resolvedProfile{PruningProfile: pruner.PruningProfile{Name: "app", Retention: a.Retention()}}, nil
+	}
+	if p, err := pruner.LookupPruningProfile(name); err == nil {
+		return resolvedProfile{PruningProfile: p}, nil
+	}
+
+	def, ok := customProfiles[name]
+	if !ok {
+		return resolvedProfile{}, fmt.Errorf("Invalid Pruning Profile %q, see %s profiles list", name, appName)
+	}
+	if seen[name] {
+		return resolvedProfile{}, fmt.Errorf("pruning profile %s extends itself", name)
+	}
+	seen[name] = true
+
+	extends := def.Extends
+	if extends == "" {
+		extends = "default"
+	}
+	base, err := resolveProfile(extends, seen)
+	if err != nil {
+		return resolvedProfile{}, err
+	}
+
+	p := resolvedProfile{
+		PruningProfile: base.PruningProfile,
+		extends:        append([]string{extends}, base.extends...),
+		stores:         make(map[string]storeDefinition),
+		source:         prunerConfigFile,
+	}
+	p.Name = name
+	if def.Blocks != nil {
+		p.KeepBlocks = *def.Blocks
+	}
+	if def.KeepVersions != nil {
+		p.KeepRecent = *def.KeepVersions
+	}
+	if def.KeepEvery != nil {
+		p.KeepEvery = *def.KeepEvery
+	}
+
+	for store, sd := range base.stores {
+		p.stores[store] = sd
+	}
+	for store, sd := range def.Stores {
+		merged := p.stores[store]
+		if sd.KeepVersions != nil {
+			merged.KeepVersions = sd.KeepVersions
+		}
+		if sd.KeepEvery != nil {
+			merged.KeepEvery = sd.KeepEvery
+		}
+		p.stores[store] = merged
+	}
+
+	return p, nil
+}
+
+// storeRetention returns the retention of every store overridden by stores, their unset values
+// are keepVersions and keepEvery
+func storeRetention(stores map[string]storeDefinition, keepVersions, keepEvery uint64) map[string]pruner.StoreRetention {
+	retention := make(map[string]pruner.StoreRetention, len(stores))
+	for store, sd := range stores {
+		r := pruner.StoreRetention{KeepRecent: keepVersions, KeepEvery: keepEvery}
+		if sd.KeepVersions != nil {
+			r.KeepRecent = *sd.KeepVersions
+		}
+		if sd.KeepEvery != nil {
+			r.KeepEvery = *sd.KeepEvery
+		}
+		retention[store] = r
+	}
+
+	return retention
+}
+
+// appProfile returns the app profile selected by --app
+func appProfile() (pruner.AppProfile, error) {
+	a, err := pruner.LookupAppProfile(app)
+	if err != nil {
+		return nil, fmt.Errorf("%w, see %s profiles list", err, appName)
+	}
+
+	return a, nil
+}
+
+func profilesCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "profiles",
+		Short: "show the app profiles selected with --app and the pruning profiles selected with --pruning",
+	}
+
+	cmd.AddCommand(profilesListCmd(), profilesShowCmd())
+
+	return cmd
+}
+
+func profilesListCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "list the built-in profiles and those of the pruner config file in the home directory",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if prunerConfigFile != "" {
+				fmt.Printf("loaded profiles from %s\n\n", prunerConfigFile)
+			}
+
+			sdkStores := make(map[string]bool)
+			for _, key := range pruner.SDKStoreKeys {
+				sdkStores[key] = true
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "APP\tSTORES\tKEEP-RECENT\tKEEP-EVERY\tMIN-RETAIN-BLOCKS\tPROTECTED\tNON-SDK STORES")
+			for _, a := range pruner.AppProfiles() {
+				extra := []string{}
+				for _, key := range a.StoreKeys() {
+					if !sdkStores[key] {
+						extra = append(extra, key)
+					}
+				}
+
+				r := a.Retention()
+				fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%s\n", a.Name(), len(a.StoreKeys()),
+					r.KeepRecent, r.KeepEvery, r.KeepBlocks, len(a.ProtectedHeights()), strings.Join(extra, ","))
+			}
+			fmt.Fprintln(w)
+
+			builtIn := pruner.PruningProfiles()
+			names := make([]string, 0, len(builtIn)+len(customProfiles)+1)
+			for _, p := range builtIn {
+				names = append(names, p.Name)
+			}
+			names = append(names, "app")
+			sort.Strings(names)
+			custom := make([]string, 0, len(customProfiles))
+			for name := range customProfiles {
+				custom = append(custom, name)
+			}
+			sort.Strings(custom)
+
+			fmt.Fprintln(w, "PROFILE\tMIN-RETAIN-BLOCKS\tKEEP-RECENT\tKEEP-EVERY\tSTORE OVERRIDES\tEXTENDS")
+			for _, name := range append(names, custom...) {
+				p, err := resolvePruningProfile(name)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\n", p.Name, p.KeepBlocks, p.KeepRecent, p.KeepEvery,
+					len(p.stores), strings.Join(p.extends, ","))
+			}
+
+			return w.Flush()
+		},
+	}
+
+	return cmd
+}
+
+func profilesShowCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "show <name>",
+		Short: "print the values of a pruning profile, with the profiles it extends applied",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, err := resolvePruningProfile(args[0])
+			if err != nil {
+				return err
+			}
+
+			source := "built-in"
+			if p.source != "" {
+				source = p.source
+			} else if p.Name == "app" {
+				source = "app profile " + app
+			}
+
+			fmt.Println("profile:", p.Name)
+			fmt.Println("source:", source)
+			if len(p.extends) > 0 {
+				fmt.Println("extends:", strings.Join(p.extends, " -> "))
+			}
+			fmt.Println("min-retain-blocks:", p.KeepBlocks)
+			fmt.Println("pruning-keep-recent:", p.KeepRecent)
+			fmt.Println("pruning-keep-every:", p.KeepEvery)
+
+			if len(p.stores) == 0 {
+				return nil
+			}
+
+			stores := storeRetention(p.stores, p.KeepRecent, p.KeepEvery)
+			names := make([]string, 0, len(stores))
+			for name := range stores {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			fmt.Println()
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "STORE\tKEEP-RECENT\tKEEP-EVERY")
+			for _, name := range names {
+				fmt.Fprintf(w, "%s\t%d\t%d\n", name, stores[name].KeepRecent, stores[name].KeepEvery)
+			}
+
+			return w.Flush()
+		},
+	}
+
+	return cmd
+}
@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const profilesTOML = `
+[profiles.Archive-Lite]
+pruning-keep-recent = 1000
+pruning-keep-every = 500
+
+[profiles.Archive-Lite.stores.IBC]
+pruning-keep-recent = 5000
+
+[profiles.Archive-Lite.stores.wasm]
+pruning-keep-every = 0
+
+[profiles.cheap]
+extends = "Archive-Lite"
+min-retain-blocks = 10
+
+[profiles.cheap.stores.IBC]
+pruning-keep-every = 50
+
+[profiles.cheap.stores.bank]
+pruning-keep-recent = 10
+`
+
+const profilesYAML = `
+profiles:
+  Archive-Lite:
+    pruning-keep-recent: 1000
+    pruning-keep-every: 500
+    stores:
+      IBC:
+        pruning-keep-recent: 5000
+      wasm:
+        pruning-keep-every: 0
+  cheap:
+    extends: Archive-Lite
+    min-retain-blocks: 10
+    stores:
+      IBC:
+        pruning-keep-every: 50
+      bank:
+        pruning-keep-recent: 10
+`
+
+// writeProfiles writes a pruner config file and reads its profiles as the custom profiles for
+// the duration of the test
+func writeProfiles(t *testing.T, name, content string) {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+
+	profiles, err := readProfiles(path)
+	require.NoError(t, err)
+
+	prevProfiles, prevFile := customProfiles, prunerConfigFile
+	t.Cleanup(func() {
+		customProfiles, prunerConfigFile = prevProfiles, prevFile
+	})
+	customProfiles, prunerConfigFile = profiles, path
+}
+
+func uint64p(v uint64) *uint64 {
+	return &v
+}
+
+func TestReadProfiles(t *testing.T) {
+	for _, file := range []struct{ name, content string }{
+		{"pruner.toml", profilesTOML},
+		{"pruner.yaml", profilesYAML},
+	} {
+		t.Run(file.name, func(t *testing.T) {
+			writeProfiles(t, file.name, file.content)
+
+			// the names of profiles and stores keep their spelling
+			require.Len(t, customProfiles, 2)
+			lite, ok := customProfiles["Archive-Lite"]
+			require.True(t, ok)
+			require.Empty(t, lite.Extends)
+			require.Nil(t, lite.Blocks)
+			require.Equal(t, uint64p(1000), lite.KeepVersions)
+			require.Equal(t, uint64p(500), lite.KeepEvery)
+			require.Equal(t, map[string]storeDefinition{
+				"IBC":  {KeepVersions: uint64p(5000)},
+				"wasm": {KeepEvery: uint64p(0)},
+			}, lite.Stores)
+
+			cheap := customProfiles["cheap"]
+			require.Equal(t, "Archive-Lite", cheap.Extends)
+			require.Equal(t, uint64p(10), cheap.Blocks)
+			require.Nil(t, cheap.KeepVersions)
+		})
+	}
+}
+
+func TestResolveProfile(t *testing.T) {
+	writeProfiles(t, "pruner.toml", profilesTOML)
+
+	p, err := resolvePruningProfile("cheap")
+	require.NoError(t, err)
+	require.Equal(t, "cheap", p.Name)
+	require.Equal(t, []string{"Archive-Lite", "default"}, p.extends)
+	require.Equal(t, uint64(10), p.KeepBlocks)
+	require.Equal(t, uint64(1000), p.KeepRecent)
+	require.Equal(t, uint64(500), p.KeepEvery)
+	require.Equal(t, prunerConfigFile, p.source)
+
+	// the store overrides of cheap are merged over those of Archive-Lite
+	require.Equal(t, map[string]storeDefinition{
+		"IBC":  {KeepVersions: uint64p(5000), KeepEvery: uint64p(50)},
+		"wasm": {KeepEvery: uint64p(0)},
+		"bank": {KeepVersions: uint64p(10)},
+	}, p.stores)
+
+	// a profile without extends extends default
+	p, err = resolvePruningProfile("Archive-Lite")
+	require.NoError(t, err)
+	require.Equal(t, []string{"default"}, p.extends)
+	require.Equal(t, uint64(0), p.KeepBlocks)
+
+	// the spelling of the name matters
+	_, err = resolvePruningProfile("archive-lite")
+	require.Error(t, err)
+
+	p, err = resolvePruningProfile("validator")
+	require.NoError(t, err)
+	require.Empty(t, p.extends)
+	require.Empty(t, p.source)
+}
+
+func TestResolveProfileInvalid(t *testing.T) {
+	writeProfiles(t, "pruner.yaml", `
+profiles:
+  a:
+    extends: b
+  b:
+    extends: c
+  c:
+    extends: a
+  self:
+    extends: self
+  orphan:
+    extends: missing
+`)
+
+	for _, name := range []string{"a", "b", "c", "self"} {
+		_, err := resolvePruningProfile(name)
+		require.Error(t, err, name)
+		require.Contains(t, err.Error(), "extends itself", name)
+	}
+
+	_, err := resolvePruningProfile("orphan")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `"missing"`)
+}
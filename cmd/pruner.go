@@ -2,55 +2,28 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
-	"sync"
-
-	"github.com/cosmos/cosmos-sdk/types"
-	sdk "github.com/cosmos/cosmos-sdk/types"
-	authtypes "github.com/cosmos/cosmos-sdk/x/auth/types"
-	banktypes "github.com/cosmos/cosmos-sdk/x/bank/types"
-	capabilitytypes "github.com/cosmos/cosmos-sdk/x/capability/types"
-	distrtypes "github.com/cosmos/cosmos-sdk/x/distribution/types"
-	evidencetypes "github.com/cosmos/cosmos-sdk/x/evidence/types"
-	govtypes "github.com/cosmos/cosmos-sdk/x/gov/types"
-	minttypes "github.com/cosmos/cosmos-sdk/x/mint/types"
-	paramstypes "github.com/cosmos/cosmos-sdk/x/params/types"
-	slashingtypes "github.com/cosmos/cosmos-sdk/x/slashing/types"
-	stakingtypes "github.com/cosmos/cosmos-sdk/x/staking/types"
-	upgradetypes "github.com/cosmos/cosmos-sdk/x/upgrade/types"
-	ibctransfertypes "github.com/cosmos/ibc-go/v2/modules/apps/transfer/types"
-	ibchost "github.com/cosmos/ibc-go/v2/modules/core/24-host"
+	"strings"
+	"time"
+
 	"github.com/neilotoole/errgroup"
 	"github.com/spf13/cobra"
-	"github.com/syndtr/goleveldb/leveldb/opt"
-	"github.com/tendermint/tendermint/state"
-	tmstore "github.com/tendermint/tendermint/store"
-	db "github.com/tendermint/tm-db"
 
-	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+	"github.com/binaryholdings/cosmos-pruner/internal/dbutil"
+	"github.com/binaryholdings/cosmos-pruner/pkg/pruner"
 )
 
-type pruningProfile struct {
-	name         string
-	blocks       uint64
-	keepVersions uint64
-	keepEvery    uint64
-}
-
 var (
-	PruningProfiles = map[string]pruningProfile{
-		"default":    {"default", 0, 400000, 100},
-		"nothing":    {"nothing", 0, 0, 1},
-		"everything": {"everything", 0, 10, 0},
-		"emitter":    {"emitter", 100000, 100, 0},
-		"rest-light": {"rest-light", 600000, 100000, 0},
-		"rest-heavy": {"rest-heavy", 0, 400000, 1000},
-		"peer":       {"peer", 0, 100, 30000},
-		"seed":       {"seed", 100000, 100, 0},
-		"sentry":     {"sentry", 300000, 100, 0},
-		"validator":  {"validator", 100000, 100, 0},
-	}
+	iavlCacheSize int
+	storeWorkers  int
+	engine        string
+	maxDuration   time.Duration
+	batchBytes    string
+	batchLatency  time.Duration
+	batchMemory   string
 )
 
 // load db
@@ -62,19 +35,17 @@ func pruneCmd() *cobra.Command {
 		Short: "prune data from the application store and block store",
 		RunE: func(cmd *cobra.Command, args []string) error {
 
-			if profile != "custom" {
-				if _, ok := PruningProfiles[profile]; !ok {
-					return fmt.Errorf("Invalid Pruning Profile")
-				}
-				if !cmd.Flag("min-retain-blocks").Changed && cmd.Flag("pruning").Changed {
-					blocks = PruningProfiles[profile].blocks
-				}
-				if !cmd.Flag("pruning-keep-recent").Changed {
-					keepVersions = PruningProfiles[profile].keepVersions
-				}
-				if !cmd.Flag("pruning-keep-every").Changed {
-					keepEvery = PruningProfiles[profile].keepEvery
-				}
+			res, err := resolveSettings(cmd)
+			if err != nil {
+				return err
+			}
+			if conflicts := res.conflicts(); len(conflicts) > 0 {
+				return fmt.Errorf("refusing to prune, see %s explain: %s", appName, strings.Join(conflicts, "; "))
+			}
+			printWarnings(res)
+			p, err := newPruner()
+			if err != nil {
+				return err
 			}
 
 			fmt.Println("app:", app)
@@ -82,267 +53,259 @@ func pruneCmd() *cobra.Command {
 			fmt.Println("pruning-keep-every:", keepEvery)
 			fmt.Println("pruning-keep-recent:", keepVersions)
 			fmt.Println("min-retain-blocks:", blocks)
+			if pruneHeights != "" {
+				fmt.Println("prune-heights:", heightRangesString(p.Options().PruneHeights))
+			}
+			if keepHeights != "" {
+				fmt.Println("keep-heights:", heightRangesString(p.Options().KeepHeights))
+			}
 			fmt.Println("batch:", batch)
 			fmt.Println("parallel-limit:", parallel)
+			fmt.Println("engine:", engine)
 
-			ctx := cmd.Context()
-			errs, _ := errgroup.WithContext(ctx)
-			var err error
-
-			if tendermint {
-				errs.Go(func() error {
-					if err = pruneTMData(homePath); err != nil {
-						return err
-					}
-
-					return nil
-				})
-			}
-
-			if cosmosSdk {
-				if err = pruneAppState(homePath); err != nil {
+			// read before pruning, since the tendermint data is pruned while the application db is open
+			if protectUpgrades {
+				upgrades, err := p.Upgrades(cmd.Context())
+				if err != nil {
 					return err
 				}
+				fmt.Println("protected upgrades:", upgradesString(upgrades, upgradeWindow))
 			}
 
-			return errs.Wait()
+			return runPrune(cmd, p, cosmosSdk, tendermint, "run prune again to continue")
 		},
 	}
 
+	// --max-duration flag
+	cmd.PersistentFlags().DurationVar(&maxDuration, "max-duration", 0, "stop pruning cleanly between batches after this time, e.g. 2h (0=no limit)")
+	// --engine flag
+	cmd.PersistentFlags().StringVar(&engine, "engine", string(pruner.EngineIAVL), "set the engine deleting the versions of the application state (iavl|orphan-sweep)")
+	// --store-workers flag
+	cmd.PersistentFlags().IntVar(&storeWorkers, "store-workers", 1, "set the amount of goroutines deleting the versions of a single store at the same time, requires --engine=orphan-sweep")
+	// --batch-bytes flag
+	cmd.PersistentFlags().StringVar(&batchBytes, "batch-bytes", "", "set the target size of a batch, e.g. 64MiB, the versions per batch then adapt to it instead of --batch")
+	// --batch-latency flag
+	cmd.PersistentFlags().DurationVar(&batchLatency, "batch-latency", 10*time.Second, "shrink adaptive batches that take longer than this to be deleted (0=no limit)")
+	// --batch-memory flag
+	cmd.PersistentFlags().StringVar(&batchMemory, "batch-memory", "", "shrink adaptive batches while the heap in use is larger than this, e.g. 2GiB")
+	// --iavl-cache-size flag
+	cmd.PersistentFlags().IntVar(&iavlCacheSize, "iavl-cache-size", pruner.DefaultIAVLCacheSize, "set the amount of IAVL nodes cached per store")
+
+	cmd.AddCommand(planCmd(), applyCmd())
+
 	return cmd
 }
 
-func compactCmd() *cobra.Command {
-
-	cmd := &cobra.Command{
-		Use:   "compact",
-		Short: "compact data from the application store and block store",
-		RunE: func(cmd *cobra.Command, args []string) error {
-
-			dbDir := rootify(dataDir, homePath)
-
-			o := opt.Options{
-				DisableSeeksCompaction: true,
-			}
-
-			if cosmosSdk {
-				// Get BlockStore
-				appDB, err := db.NewGoLevelDBWithOpts("application", dbDir, &o)
-				if err != nil {
-					return err
-				}
-
-				fmt.Println("compacting application state")
-				if err := appDB.ForceCompact(nil, nil); err != nil {
-					return err
-				}
-			}
-
-			if tendermint {
-				// Get BlockStore
-				blockStoreDB, err := db.NewGoLevelDBWithOpts("blockstore", dbDir, &o)
-				if err != nil {
-					return err
-				}
-
-				fmt.Println("compacting block store")
-				if err := blockStoreDB.ForceCompact(nil, nil); err != nil {
-					return err
-				}
-
-				// Get StateStore
-				stateDB, err := db.NewGoLevelDBWithOpts("state", dbDir, &o)
-				if err != nil {
-					return err
-				}
+// runPrune prunes the application state if app is set and the tendermint data if tm is set, resume
+// tells how to continue after max-duration
+func runPrune(cmd *cobra.Command, p *pruner.Pruner, app, tm bool, resume string) error {
+	ctx, stop := notifyContext(cmd.Context())
+	defer stop()
+	if maxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, maxDuration)
+		defer cancel()
+	}
+	errs, _ := errgroup.WithContext(ctx)
 
-				fmt.Println("compacting state store")
-				if err := stateDB.ForceCompact(nil, nil); err != nil {
-					return err
-				}
+	if tm {
+		errs.Go(func() error {
+			if _, err := p.PruneTendermint(ctx); err != nil && err != ctx.Err() {
+				return fmt.Errorf("failed to prune tendermint data: %w", err)
 			}
 
 			return nil
-		},
+		})
 	}
 
-	return cmd
-}
-
-func pruneAppState(home string) error {
-	dbDir := rootify(dataDir, home)
-
-	o := opt.Options{
-		DisableSeeksCompaction: true,
+	var appErr error
+	if app {
+		appErr = pruneAppState(ctx, p)
 	}
 
-	// Get BlockStore
-	appDB, err := db.NewGoLevelDBWithOpts("application", dbDir, &o)
-	if err != nil {
+	// both are reported, the tendermint data is pruned even if the application state fails
+	if err := errs.Wait(); err != nil {
+		if appErr != nil {
+			fmt.Fprintln(os.Stderr, "Error:", appErr)
+		}
 		return err
 	}
-
-	fmt.Println("pruning application state")
-
-	// only mount keys from core sdk
-	// todo allow for other keys to be mounted
-	keys := types.NewKVStoreKeys(
-		authtypes.StoreKey, banktypes.StoreKey, stakingtypes.StoreKey,
-		minttypes.StoreKey, distrtypes.StoreKey, slashingtypes.StoreKey,
-		govtypes.StoreKey, paramstypes.StoreKey, ibchost.StoreKey, upgradetypes.StoreKey,
-		evidencetypes.StoreKey, ibctransfertypes.StoreKey, capabilitytypes.StoreKey,
-	)
-
-	if app == "bandchain" {
-		bandchainKeys := types.NewKVStoreKeys(
-			"feegrant", // feegrant.StoreKey,
-			"authz",    // authzkeeper.StoreKey,
-			"oracle",   // oracletypes.StoreKey,
-			"icahost",
-		)
-
-		for key, value := range bandchainKeys {
-			keys[key] = value
-		}
+	if appErr != nil {
+		return appErr
 	}
-
-	extraKeys := types.NewKVStoreKeys(modules...)
-
-	for key, value := range extraKeys {
-		keys[key] = value
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		fmt.Printf("max-duration of %s reached, %s\n", maxDuration, resume)
+	case context.Canceled:
+		return interruptedError()
 	}
 
-	wg := sync.WaitGroup{}
-	var pruneErr error
-
-	guard := make(chan struct{}, parallel)
-	for _, value := range keys {
-		guard <- struct{}{}
-		wg.Add(1)
-		go func(value *types.KVStoreKey) {
-			err := func(value *types.KVStoreKey) error {
-				appStore := rootmulti.NewStore(appDB)
-				appStore.MountStoreWithDB(value, sdk.StoreTypeIAVL, nil)
-				err = appStore.LoadLatestVersion()
-				if err != nil {
-					return err
-				}
-
-				versions := appStore.GetAllVersions()
+	return nil
+}
 
-				v64 := make([]int64, 0)
-				for i := 0; i < len(versions); i++ {
-					if (keepEvery == 0 || versions[i]%int(keepEvery) != 0) &&
-						versions[i] <= versions[len(versions)-1]-int(keepVersions) {
-						v64 = append(v64, int64(versions[i]))
-					}
-				}
+// newPruner returns a pruner configured by the flags, which prints its progress
+func newPruner() (*pruner.Pruner, error) {
+	opts, err := prunerOptions()
+	if err != nil {
+		return nil, err
+	}
+	opts.OnProgress = func(p pruner.Progress) {
+		fmt.Println(p.Message)
+	}
 
-				appStore.PruneHeights = v64[:]
+	return pruner.New(opts)
+}
 
-				fmt.Printf("pruning store: %+v (%d/%d)\n", value.Name(), len(v64), len(versions))
-				appStore.PruneStores(int(batch))
-				fmt.Println("finished pruning store:", value.Name())
+// newReportPruner returns a pruner configured by the flags, which prints its progress to stderr
+// so that it does not mix with a report printed to stdout
+func newReportPruner() (*pruner.Pruner, error) {
+	opts, err := prunerOptions()
+	if err != nil {
+		return nil, err
+	}
+	opts.OnProgress = func(p pruner.Progress) {
+		fmt.Fprintln(os.Stderr, p.Message)
+	}
 
-				return nil
-			}(value)
+	return pruner.New(opts)
+}
 
-			if err != nil {
-				pruneErr = err
-			}
-			<-guard
-			defer wg.Done()
-		}(value)
+// prunerOptions returns the options of a pruner configured by the flags
+func prunerOptions() (pruner.Options, error) {
+	a, err := appProfile()
+	if err != nil {
+		return pruner.Options{}, err
 	}
-	wg.Wait()
 
-	if pruneErr != nil {
-		return pruneErr
+	opts := pruner.Options{
+		Home:               homePath,
+		DataDir:            dataDir,
+		App:                a,
+		Modules:            modules,
+		KeepRecent:         keepVersions,
+		KeepEvery:          keepEvery,
+		KeepBlocks:         blocks,
+		Stores:             storeRetention(profileStores, keepVersions, keepEvery),
+		Engine:             pruner.Engine(engine),
+		Batch:              batch,
+		BatchLatency:       batchLatency,
+		Parallel:           int(parallel),
+		StoreWorkers:       storeWorkers,
+		IAVLCacheSize:      iavlCacheSize,
+		CosmosSDK:          cosmosSdk,
+		Tendermint:         tendermint,
+		OutDir:             outDir,
+		Plan:               pruningPlan,
+		ProtectUpgrades:    protectUpgrades,
+		UpgradeWindow:      upgradeWindow,
+		ProtectSnapshots:   protectSnapshots,
+		SnapshotInterval:   snapshotInterval,
+		SnapshotKeepRecent: snapshotKeepRecent,
 	}
 
-	fmt.Println("compacting application state")
-	if err := appDB.ForceCompact(nil, nil); err != nil {
-		return err
+	if opts.PruneHeights, err = heightsFlag("prune-heights", pruneHeights); err != nil {
+		return opts, err
+	}
+	if opts.KeepHeights, err = heightsFlag("keep-heights", keepHeights); err != nil {
+		return opts, err
+	}
+	if batchBytes != "" {
+		if opts.BatchBytes, err = dbutil.ParseBytes(batchBytes); err != nil {
+			return opts, fmt.Errorf("invalid batch-bytes: %w", err)
+		}
+	}
+	if batchMemory != "" {
+		heap, err := dbutil.ParseBytes(batchMemory)
+		if err != nil {
+			return opts, fmt.Errorf("invalid batch-memory: %w", err)
+		}
+		opts.BatchMemory = uint64(heap)
 	}
 
-	return nil
+	return opts, nil
 }
 
-// pruneTMData prunes the tendermint blocks and state based on the amount of blocks to keep
-func pruneTMData(home string) error {
-	dbDir := rootify(dataDir, home)
-
-	o := opt.Options{
-		DisableSeeksCompaction: true,
+// heightsFlag parses the height ranges of a flag, which are read from a file when the value starts
+// with @
+func heightsFlag(name, value string) ([]pruner.HeightRange, error) {
+	if strings.HasPrefix(value, "@") {
+		bz, err := os.ReadFile(value[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		value = string(bz)
 	}
 
-	// Get BlockStore
-	blockStoreDB, err := db.NewGoLevelDBWithOpts("blockstore", dbDir, &o)
+	ranges, err := pruner.ParseHeightRanges(value)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
 	}
-	blockStore := tmstore.NewBlockStore(blockStoreDB)
 
-	// Get StateStore
-	stateDB, err := db.NewGoLevelDBWithOpts("state", dbDir, &o)
-	if err != nil {
-		return err
-	}
+	return ranges, nil
+}
 
-	stateStore := state.NewStore(stateDB)
+// heightRangesString joins height ranges like they are given
+func heightRangesString(ranges []pruner.HeightRange) string {
+	list := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		list = append(list, r.String())
+	}
 
-	base := blockStore.Base()
+	return strings.Join(list, ",")
+}
 
-	if blocks == 0 {
-		return nil
+// printWarnings prints the warnings about the settings
+func printWarnings(res *resolution) {
+	for _, w := range res.warnings() {
+		fmt.Fprintln(os.Stderr, "warning:", w)
 	}
-	if blocks < 100000 {
-		return fmt.Errorf("Your min-retain-blocks %+v is lower than the minimum 100000", blocks)
-	}
-
-	pruneHeight := blockStore.Height() - int64(blocks)
+}
 
-	errs, _ := errgroup.WithContext(context.Background())
-	errs.Go(func() error {
-		fmt.Println("pruning block store")
-		// prune block store
-		if base < pruneHeight {
-			blocks, err = blockStore.PruneBlocks(pruneHeight)
-			if err != nil {
-				return err
-			}
-		}
+// upgradesString lists upgrades with their heights and the window kept around them
+func upgradesString(upgrades []pruner.Upgrade, window uint64) string {
+	if len(upgrades) == 0 {
+		return "none"
+	}
 
-		fmt.Println("compacting block store")
-		if err := blockStoreDB.ForceCompact(nil, nil); err != nil {
-			return err
-		}
+	list := make([]string, 0, len(upgrades))
+	for _, u := range upgrades {
+		list = append(list, fmt.Sprintf("%s at %d", u.Name, u.Height))
+	}
+	s := strings.Join(list, ", ")
+	if window > 0 {
+		s += fmt.Sprintf(" (+/- %d heights)", window)
+	}
 
-		return nil
-	})
+	return s
+}
 
-	fmt.Println("pruning state store")
+// pruneAppState prunes the application state and prints the outcome of every store
+func pruneAppState(ctx context.Context, p *pruner.Pruner) error {
+	result, err := p.PruneApp(ctx)
+	if result == nil {
+		return err
+	}
 
-	// prune state store
-	if base < pruneHeight {
-		err = stateStore.PruneStates(base, pruneHeight)
-		if err != nil {
-			return err
+	for _, r := range result.Stores {
+		if r.Err != nil {
+			fmt.Printf("store %s: failed: %v\n", r.Name, r.Err)
+		} else if r.Stopped {
+			fmt.Printf("store %s: %s after pruning %d/%d versions up to version %d in %d batches\n",
+				r.Name, pruner.StopReason(ctx), r.Pruned, r.ToPrune, r.Last, r.Batches)
+		} else {
+			fmt.Printf("store %s: pruned %d/%d versions in %d batches in %s (%.1f versions/s), heap %s\n",
+				r.Name, r.Pruned, r.Versions, r.Batches, r.Duration.Round(time.Millisecond), r.Rate(), dbutil.FormatBytes(int64(r.Heap)))
 		}
 	}
 
-	fmt.Println("compacting state store")
-	if err := stateDB.ForceCompact(nil, nil); err != nil {
-		return err
+	var storesErr *pruner.StoresError
+	if errors.As(err, &storesErr) {
+		return &exitError{code: exitPartial, err: err}
 	}
-
-	if err := errs.Wait(); err != nil {
+	if err != nil && err != ctx.Err() {
 		return err
 	}
 
-	stateDB.Close()
-	blockStore.Close()
-
 	return nil
 }
 
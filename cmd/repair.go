@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/binaryholdings/cosmos-pruner/pkg/pruner"
+)
+
+var (
+	repairDryRun bool
+)
+
+func repairCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "repair",
+		Short: "remove the leftovers of interrupted prunes from the application db",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, err := newPruner()
+			if err != nil {
+				return err
+			}
+
+			ctx, stop := notifyContext(cmd.Context())
+			defer stop()
+
+			result, err := p.Repair(ctx, repairDryRun)
+			if result == nil {
+				return err
+			}
+
+			for _, r := range result.Stores {
+				if repairDryRun {
+					// the dangling orphans are only found after the broken versions are deleted
+					fmt.Printf("store %s: %d broken versions %v would be removed, an estimated %d nodes and %d dangling orphans with them\n",
+						r.Name, len(r.Broken), r.Broken, r.Nodes, r.Orphans)
+					continue
+				}
+				fmt.Printf("store %s: %d broken versions %v, %d nodes and %d dangling orphans removed\n",
+					r.Name, len(r.Broken), r.Broken, r.Nodes, r.Orphans)
+			}
+
+			if err != nil && err != ctx.Err() {
+				return err
+			}
+			if ctx.Err() != nil {
+				fmt.Printf("%s, %d of %d stores repaired\n", pruner.StopReason(ctx), len(result.Stores), result.StoreCount)
+				return interruptedError()
+			}
+
+			if result.PruningHeights > 0 {
+				fmt.Printf("pruning heights: %d of %d are already gone\n", result.Gone, result.PruningHeights)
+			}
+
+			return nil
+		},
+	}
+
+	// --dry-run flag
+	cmd.Flags().BoolVar(&repairDryRun, "dry-run", false, "only report what would be removed, the nodes and orphans removed with the broken versions are estimated")
+
+	return cmd
+}
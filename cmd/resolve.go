@@ -0,0 +1,281 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/binaryholdings/cosmos-pruner/pkg/pruner"
+)
+
+// envPrefix prefixes the environment variables of the pruning settings, e.g.
+// COSMOS_PRUNER_PRUNING_KEEP_RECENT for pruning-keep-recent
+const envPrefix = "COSMOS_PRUNER_"
+
+// valueSource is where a value of a setting is given
+type valueSource string
+
+const (
+	sourceFlag    valueSource = "flag"
+	sourceEnv     valueSource = "env"
+	sourceAppToml valueSource = "app.toml"
+	sourceProfile valueSource = "profile"
+	sourceDefault valueSource = "default"
+)
+
+// retentionSettings are the settings a pruning profile sets
+var retentionSettings = []string{"min-retain-blocks", "pruning-keep-recent", "pruning-keep-every"}
+
+// snapshotSettings are the state-sync settings of app.toml and their defaults in the cosmos-sdk
+var snapshotSettings = []struct{ name, def string }{
+	{"snapshot-interval", "0"},
+	{"snapshot-keep-recent", "2"},
+}
+
+// candidate is a value given for a setting
+type candidate struct {
+	source valueSource
+	// origin is the flag, variable, file or profile giving the value
+	origin string
+	value  string
+}
+
+func (c candidate) String() string {
+	return fmt.Sprintf("%s from %s", c.value, c.from())
+}
+
+// from describes where the value is given
+func (c candidate) from() string {
+	if c.origin == "" {
+		return string(c.source)
+	}
+	return fmt.Sprintf("%s %s", c.source, c.origin)
+}
+
+// setting is a setting with the values given for it in order of precedence, the first one is
+// effective
+type setting struct {
+	name       string
+	candidates []candidate
+}
+
+func (s setting) effective() candidate {
+	return s.candidates[0]
+}
+
+// explicit returns whether the effective value was given for this run, by a flag or environment
+// variable
+func (s setting) explicit() bool {
+	source := s.effective().source
+	return source == sourceFlag || source == sourceEnv
+}
+
+// resolution is the effective pruning settings and where they come from
+type resolution struct {
+	settings []setting
+	// profile is the selected pruning profile, unless it is custom
+	profile *resolvedProfile
+	// notes explain values that were given but are not considered
+	notes []string
+}
+
+// setting returns the setting of the given name
+func (r *resolution) setting(name string) setting {
+	for _, s := range r.settings {
+		if s.name == name {
+			return s
+		}
+	}
+	return setting{name: name}
+}
+
+// resolveSettings resolves the pruning profile and the retention settings and sets them. Every
+// setting is taken from a flag, then an environment variable, then app.toml, then its default.
+// A pruning profile other than custom overrides the keep-recent and keep-every of app.toml like
+// the cosmos-sdk does, and its min-retain-blocks is only applied when the profile is selected by
+// a flag or environment variable, since app.toml selects one for the application state only.
+func resolveSettings(cmd *cobra.Command) (*resolution, error) {
+	appToml := viper.New()
+	if path := viper.ConfigFileUsed(); path != "" {
+		appToml.SetConfigFile(path)
+		if err := appToml.ReadInConfig(); err != nil {
+			return nil, err
+		}
+	}
+
+	res := &resolution{}
+	pruning := setting{name: "pruning", candidates: givenValues(cmd, appToml, "pruning")}
+	res.settings = append(res.settings, pruning)
+	profile = pruning.effective().value
+
+	if profile != "custom" {
+		p, err := resolvePruningProfile(profile)
+		if err != nil {
+			return nil, err
+		}
+		res.profile = &p
+	}
+
+	for _, name := range retentionSettings {
+		s := setting{name: name}
+		given := givenValues(cmd, appToml, name)
+
+		// the values of this run come before the profile, app.toml and the default after it
+		i := 0
+		for i < len(given) && (given[i].source == sourceFlag || given[i].source == sourceEnv) {
+			i++
+		}
+		s.candidates = append(s.candidates, given[:i]...)
+		if res.profile != nil {
+			c := candidate{source: sourceProfile, origin: res.profile.Name, value: strconv.FormatUint(res.profile.value(name), 10)}
+			if name != "min-retain-blocks" || pruning.explicit() {
+				s.candidates = append(s.candidates, c)
+			} else {
+				res.notes = append(res.notes, fmt.Sprintf(
+					"min-retain-blocks %s of profile %s is not applied, as the profile is selected by %s and not by a flag or environment variable",
+					c.value, c.origin, pruning.effective().source))
+			}
+		}
+		s.candidates = append(s.candidates, given[i:]...)
+
+		res.settings = append(res.settings, s)
+	}
+
+	// the snapshots are taken by the node, so their settings only come from app.toml
+	for _, def := range snapshotSettings {
+		s := setting{name: def.name}
+		if key := "state-sync." + def.name; appToml.IsSet(key) {
+			s.candidates = append(s.candidates, candidate{sourceAppToml, appToml.ConfigFileUsed(), appToml.GetString(key)})
+		}
+		s.candidates = append(s.candidates, candidate{source: sourceDefault, value: def.def})
+		res.settings = append(res.settings, s)
+	}
+
+	var err error
+	if snapshotInterval, err = res.uint64("snapshot-interval"); err != nil {
+		return nil, err
+	}
+	if snapshotKeepRecent, err = res.uint64("snapshot-keep-recent"); err != nil {
+		return nil, err
+	}
+	if blocks, err = res.uint64("min-retain-blocks"); err != nil {
+		return nil, err
+	}
+	if keepVersions, err = res.uint64("pruning-keep-recent"); err != nil {
+		return nil, err
+	}
+	if keepEvery, err = res.uint64("pruning-keep-every"); err != nil {
+		return nil, err
+	}
+	profileStores = nil
+	if res.profile != nil {
+		profileStores = res.profile.stores
+	}
+
+	return res, nil
+}
+
+// givenValues returns the values given for a setting by a flag, an environment variable,
+// app.toml and its default, in this order
+func givenValues(cmd *cobra.Command, appToml *viper.Viper, name string) []candidate {
+	given := []candidate{}
+
+	flag := cmd.Flag(name)
+	if flag != nil && flag.Changed {
+		given = append(given, candidate{sourceFlag, "--" + name, flag.Value.String()})
+	}
+	env := envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+	if value, ok := os.LookupEnv(env); ok {
+		given = append(given, candidate{sourceEnv, env, value})
+	}
+	if appToml.IsSet(name) {
+		given = append(given, candidate{sourceAppToml, appToml.ConfigFileUsed(), appToml.GetString(name)})
+	}
+	if flag != nil {
+		given = append(given, candidate{sourceDefault, "", flag.DefValue})
+	}
+
+	return given
+}
+
+// uint64 parses the effective value of a setting
+func (r *resolution) uint64(name string) (uint64, error) {
+	c := r.setting(name).effective()
+	v, err := strconv.ParseUint(c.value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q from %s", name, c.value, c.from())
+	}
+
+	return v, nil
+}
+
+// value returns the value of a retention setting of the profile
+func (p resolvedProfile) value(name string) uint64 {
+	switch name {
+	case "min-retain-blocks":
+		return p.KeepBlocks
+	case "pruning-keep-recent":
+		return p.KeepRecent
+	default:
+		return p.KeepEvery
+	}
+}
+
+// conflicts returns the contradictory combinations of settings, which prune refuses
+func (r *resolution) conflicts() []string {
+	conflicts := []string{}
+
+	pruning := r.setting("pruning")
+	if r.profile != nil && pruning.explicit() {
+		for _, name := range retentionSettings {
+			s := r.setting(name)
+			c := s.effective()
+			if s.explicit() && c.value != strconv.FormatUint(r.profile.value(name), 10) {
+				conflicts = append(conflicts, fmt.Sprintf(
+					"profile %s sets %s to %d but %s sets it to %s, select --pruning=custom to set the retention yourself",
+					r.profile.Name, name, r.profile.value(name), c.from(), c.value))
+			}
+		}
+	}
+
+	if !cosmosSdk && !tendermint {
+		conflicts = append(conflicts, "--cosmos-sdk=false and --tendermint=false leave nothing to prune")
+	}
+
+	if tendermint && blocks > 0 && blocks < pruner.MinKeepBlocks {
+		c := r.setting("min-retain-blocks").effective()
+		conflicts = append(conflicts, fmt.Sprintf("min-retain-blocks %d from %s is lower than the minimum %d",
+			blocks, c.from(), pruner.MinKeepBlocks))
+	}
+
+	return conflicts
+}
+
+// warnings returns the settings that prune accepts, but that conflict with the snapshots of the node
+func (r *resolution) warnings() []string {
+	warnings := []string{}
+	if snapshotInterval == 0 {
+		return warnings
+	}
+
+	if keepEvery > 0 && snapshotInterval%keepEvery != 0 {
+		warnings = append(warnings, fmt.Sprintf(
+			"snapshot-interval %d is not a multiple of pruning-keep-every %d, the node refuses to start with this",
+			snapshotInterval, keepEvery))
+	}
+	if !protectSnapshots {
+		warnings = append(warnings, "the versions the snapshots are taken at are pruned unless --protect-snapshots is set, "+
+			"they can then no longer be regenerated or verified")
+	}
+	if tendermint && blocks > 0 && snapshotKeepRecent > 0 && blocks < snapshotInterval*snapshotKeepRecent {
+		warnings = append(warnings, fmt.Sprintf(
+			"min-retain-blocks %d prunes blocks the node keeps for its %d recent snapshots every %d blocks, which state sync needs",
+			blocks, snapshotKeepRecent, snapshotInterval))
+	}
+
+	return warnings
+}
@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveSettings(t *testing.T) {
+	testCases := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		appToml string
+		// the effective values and their sources
+		blocks, keepRecent, keepEvery uint64
+		sources                       map[string]valueSource
+		notes                         int
+		conflicts                     []string
+		warnings                      []string
+	}{
+		{
+			name:       "defaults",
+			blocks:     0,
+			keepRecent: 400000,
+			keepEvery:  100,
+			sources:    map[string]valueSource{"pruning": sourceDefault, "pruning-keep-recent": sourceProfile},
+			notes:      1,
+		},
+		{
+			name:       "env overrides app.toml",
+			env:        map[string]string{"COSMOS_PRUNER_PRUNING_KEEP_RECENT": "50"},
+			appToml:    "pruning = \"custom\"\npruning-keep-recent = \"100\"\npruning-keep-every = \"0\"\n",
+			keepRecent: 50,
+			sources: map[string]valueSource{
+				"pruning": sourceAppToml, "pruning-keep-recent": sourceEnv, "pruning-keep-every": sourceAppToml,
+			},
+		},
+		{
+			name:       "flag overrides env",
+			args:       []string{"--pruning=custom", "--pruning-keep-recent=20"},
+			env:        map[string]string{"COSMOS_PRUNER_PRUNING_KEEP_RECENT": "50"},
+			keepRecent: 20,
+			keepEvery:  100,
+			sources:    map[string]valueSource{"pruning": sourceFlag, "pruning-keep-recent": sourceFlag},
+		},
+		{
+			name:       "profile overrides app.toml",
+			args:       []string{"--pruning=everything"},
+			appToml:    "pruning = \"custom\"\npruning-keep-recent = \"100\"\npruning-keep-every = \"7\"\n",
+			keepRecent: 10,
+			keepEvery:  0,
+			sources:    map[string]valueSource{"pruning": sourceFlag, "pruning-keep-recent": sourceProfile, "pruning-keep-every": sourceProfile},
+		},
+		{
+			name:       "profile of app.toml overrides its retention",
+			appToml:    "pruning = \"everything\"\npruning-keep-recent = \"100\"\n",
+			keepRecent: 10,
+			sources:    map[string]valueSource{"pruning": sourceAppToml, "pruning-keep-recent": sourceProfile},
+			notes:      1,
+		},
+		{
+			name:       "min-retain-blocks of a profile of app.toml is not applied",
+			appToml:    "pruning = \"validator\"\n",
+			keepRecent: 100,
+			sources:    map[string]valueSource{"pruning": sourceAppToml, "min-retain-blocks": sourceDefault},
+			notes:      1,
+		},
+		{
+			name:       "min-retain-blocks of a profile of a flag is applied",
+			args:       []string{"--pruning=validator"},
+			appToml:    "min-retain-blocks = \"500000\"\n",
+			blocks:     100000,
+			keepRecent: 100,
+			sources:    map[string]valueSource{"pruning": sourceFlag, "min-retain-blocks": sourceProfile},
+		},
+		{
+			name:       "retention of a flag conflicts with a profile of a flag",
+			args:       []string{"--pruning=validator", "--pruning-keep-recent=5"},
+			blocks:     100000,
+			keepRecent: 5,
+			sources:    map[string]valueSource{"pruning": sourceFlag, "pruning-keep-recent": sourceFlag},
+			conflicts: []string{
+				"profile validator sets pruning-keep-recent to 100 but flag --pruning-keep-recent sets it to 5, select --pruning=custom to set the retention yourself",
+			},
+		},
+		{
+			name:       "retention of a flag equal to the profile does not conflict",
+			args:       []string{"--pruning=validator", "--pruning-keep-recent=100"},
+			blocks:     100000,
+			keepRecent: 100,
+			sources:    map[string]valueSource{"pruning-keep-recent": sourceFlag},
+		},
+		{
+			name:       "protected snapshots",
+			args:       []string{"--pruning=custom", "--pruning-keep-every=0", "--protect-snapshots"},
+			appToml:    "[state-sync]\nsnapshot-interval = 100000\n",
+			keepRecent: 400000,
+		},
+		{
+			name:       "nothing to prune",
+			args:       []string{"--cosmos-sdk=false", "--tendermint=false"},
+			keepRecent: 400000,
+			keepEvery:  100,
+			notes:      1,
+			conflicts:  []string{"--cosmos-sdk=false and --tendermint=false leave nothing to prune"},
+		},
+		{
+			name:       "min-retain-blocks below the minimum",
+			args:       []string{"--pruning=custom", "--min-retain-blocks=10"},
+			blocks:     10,
+			keepRecent: 400000,
+			keepEvery:  100,
+			conflicts:  []string{"min-retain-blocks 10 from flag --min-retain-blocks is lower than the minimum 100000"},
+		},
+		{
+			name:       "snapshots",
+			args:       []string{"--pruning=custom", "--pruning-keep-every=300", "--min-retain-blocks=150000"},
+			appToml:    "[state-sync]\nsnapshot-interval = 100000\n",
+			blocks:     150000,
+			keepRecent: 400000,
+			keepEvery:  300,
+			sources:    map[string]valueSource{"snapshot-interval": sourceAppToml, "snapshot-keep-recent": sourceDefault},
+			warnings: []string{
+				"snapshot-interval 100000 is not a multiple of pruning-keep-every 300, the node refuses to start with this",
+				"the versions the snapshots are taken at are pruned unless --protect-snapshots is set, they can then no longer be regenerated or verified",
+				"min-retain-blocks 150000 prunes blocks the node keeps for its 2 recent snapshots every 100000 blocks, which state sync needs",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for name, value := range tc.env {
+				t.Setenv(name, value)
+			}
+
+			viper.Reset()
+			t.Cleanup(viper.Reset)
+			if tc.appToml != "" {
+				path := filepath.Join(t.TempDir(), "app.toml")
+				require.NoError(t, os.WriteFile(path, []byte(tc.appToml), 0644))
+				viper.SetConfigFile(path)
+			}
+
+			cmd := NewRootCmd()
+			require.NoError(t, cmd.ParseFlags(tc.args))
+
+			res, err := resolveSettings(cmd)
+			require.NoError(t, err)
+
+			require.Equal(t, tc.blocks, blocks, "min-retain-blocks")
+			require.Equal(t, tc.keepRecent, keepVersions, "pruning-keep-recent")
+			require.Equal(t, tc.keepEvery, keepEvery, "pruning-keep-every")
+			for name, source := range tc.sources {
+				require.Equal(t, source, res.setting(name).effective().source, name)
+			}
+			require.Len(t, res.notes, tc.notes)
+
+			conflicts := tc.conflicts
+			if conflicts == nil {
+				conflicts = []string{}
+			}
+			require.Equal(t, conflicts, res.conflicts())
+
+			warnings := tc.warnings
+			if warnings == nil {
+				warnings = []string{}
+			}
+			require.Equal(t, warnings, res.warnings())
+		})
+	}
+}
+
+func TestResolveSettingsInvalid(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	t.Setenv("COSMOS_PRUNER_PRUNING_KEEP_EVERY", "often")
+
+	cmd := NewRootCmd()
+	require.NoError(t, cmd.ParseFlags([]string{"--pruning=custom"}))
+	_, err := resolveSettings(cmd)
+	require.EqualError(t, err, `invalid pruning-keep-every "often" from env COSMOS_PRUNER_PRUNING_KEEP_EVERY`)
+
+	require.NoError(t, cmd.ParseFlags([]string{"--pruning=unknown"}))
+	_, err = resolveSettings(cmd)
+	require.Error(t, err)
+}
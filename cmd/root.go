@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
@@ -23,7 +24,16 @@ var (
 	parallel     uint64
 	profile      string
 	modules      []string
-	appName      = "cosmos-pruner"
+	pruneHeights string
+	keepHeights  string
+
+	protectUpgrades bool
+	upgradeWindow   uint64
+
+	protectSnapshots   bool
+	snapshotInterval   uint64
+	snapshotKeepRecent uint64
+	appName            = "cosmos-pruner"
 )
 
 func cobraInit(rootCmd *cobra.Command) error {
@@ -44,19 +54,14 @@ func cobraInit(rootCmd *cobra.Command) error {
 		return fmt.Errorf("Error loading config file. %+v", err)
 	}
 	if viper.ConfigFileUsed() != "" {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
 	}
 	// Bind flags from the command line to the viper framework
 	if err := viper.BindPFlags(rootCmd.Flags()); err != nil {
 		return err
 	}
 
-	blocks = viper.GetUint64("min-retain-blocks")
-	profile = viper.GetString("pruning")
-	keepVersions = viper.GetUint64("pruning-keep-recent")
-	keepEvery = viper.GetUint64("pruning-keep-every")
-
-	return nil
+	return loadPrunerConfig()
 }
 
 // NewRootCmd returns the root command for relayer.
@@ -131,6 +136,41 @@ func NewRootCmd() *cobra.Command {
 		panic(err)
 	}
 
+	// --prune-heights flag
+	rootCmd.PersistentFlags().
+		StringVar(&pruneHeights, "prune-heights", "", "versions to be pruned regardless of the retention, e.g. \"100-5000,7000\" or @file")
+	if err := viper.BindPFlag("prune-heights", rootCmd.PersistentFlags().Lookup("prune-heights")); err != nil {
+		panic(err)
+	}
+
+	// --keep-heights flag
+	rootCmd.PersistentFlags().
+		StringVar(&keepHeights, "keep-heights", "", "versions never to be pruned, e.g. \"100-5000,7000\" or @file")
+	if err := viper.BindPFlag("keep-heights", rootCmd.PersistentFlags().Lookup("keep-heights")); err != nil {
+		panic(err)
+	}
+
+	// --protect-upgrades flag
+	rootCmd.PersistentFlags().
+		BoolVar(&protectUpgrades, "protect-upgrades", false, "keep the versions and blocks at the heights of the completed upgrade plans")
+	if err := viper.BindPFlag("protect-upgrades", rootCmd.PersistentFlags().Lookup("protect-upgrades")); err != nil {
+		panic(err)
+	}
+
+	// --upgrade-window flag
+	rootCmd.PersistentFlags().
+		Uint64Var(&upgradeWindow, "upgrade-window", 0, "set the amount of heights kept before and after every upgrade height, requires --protect-upgrades")
+	if err := viper.BindPFlag("upgrade-window", rootCmd.PersistentFlags().Lookup("upgrade-window")); err != nil {
+		panic(err)
+	}
+
+	// --protect-snapshots flag
+	rootCmd.PersistentFlags().
+		BoolVar(&protectSnapshots, "protect-snapshots", false, "keep the versions of the local state-sync snapshots and those snapshot-interval and snapshot-keep-recent of app.toml take snapshots of")
+	if err := viper.BindPFlag("protect-snapshots", rootCmd.PersistentFlags().Lookup("protect-snapshots")); err != nil {
+		panic(err)
+	}
+
 	// --backend flag
 	rootCmd.PersistentFlags().
 		StringVar(&backend, "backend", "goleveldb", "set the type of db being used")
@@ -141,7 +181,7 @@ func NewRootCmd() *cobra.Command {
 
 	// --app flag
 	rootCmd.PersistentFlags().
-		StringVar(&app, "app", "bandchain", "set the app you are pruning")
+		StringVar(&app, "app", "bandchain", "set the app you are pruning, see profiles list")
 	if err := viper.BindPFlag("app", rootCmd.PersistentFlags().Lookup("app")); err != nil {
 		panic(err)
 	}
@@ -163,6 +203,15 @@ func NewRootCmd() *cobra.Command {
 	rootCmd.AddCommand(
 		pruneCmd(),
 		compactCmd(),
+		statusCmd(),
+		profilesCmd(),
+		explainCmd(),
+		estimateCmd(),
+		analyzeCmd(),
+		churnCmd(),
+		fsckCmd(),
+		repairCmd(),
+		snapshotCmd(),
 	)
 
 	return rootCmd
@@ -178,6 +227,10 @@ func Execute() {
 	rootCmd.CompletionOptions.DisableDefaultCmd = true
 
 	if err := rootCmd.Execute(); err != nil {
-		os.Exit(1)
+		var exitErr *exitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.code)
+		}
+		os.Exit(exitFailure)
 	}
 }
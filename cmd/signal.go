@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyContext returns a context that is canceled on the first SIGINT or SIGTERM, so that a
+// command writing to the dbs stops between batches and closes them. The signals are handled as
+// usual again afterwards, a second one aborts right away.
+func notifyContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case sig := <-sigs:
+			fmt.Fprintf(os.Stderr, "received %s, stopping after the current batches, send it again to abort\n", sig)
+			signal.Stop(sigs)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(sigs)
+		cancel()
+	}
+}
+
+// interruptedError is returned by a command that stopped on a signal
+func interruptedError() error {
+	return &exitError{code: exitInterrupted, err: fmt.Errorf("interrupted, run the command again to continue")}
+}
@@ -0,0 +1,206 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/dbutil"
+	"github.com/binaryholdings/cosmos-pruner/pkg/pruner"
+)
+
+var (
+	snapshotKeep    uint
+	snapshotDryRun  bool
+	snapshotTempDir string
+)
+
+func snapshotCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "snapshot",
+		Short: "manage the local state-sync snapshots in data/snapshots",
+	}
+
+	cmd.AddCommand(snapshotListCmd(), snapshotPruneCmd(), snapshotVerifyCmd())
+
+	return cmd
+}
+
+func snapshotListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "list the local snapshots and the chunk directories without a snapshot",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, err := newPruner()
+			if err != nil {
+				return err
+			}
+
+			list, err := p.Snapshots(cmd.Context())
+			if err != nil {
+				return err
+			}
+			orphaned, err := p.OrphanedSnapshots(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			if err := printSnapshots(list); err != nil {
+				return err
+			}
+			for _, dir := range orphaned {
+				fmt.Println("orphaned chunk directory:", dir)
+			}
+
+			return nil
+		},
+	}
+}
+
+func snapshotPruneCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "delete all but the latest snapshots and the orphaned chunk directories, then check the chunks of those kept",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, err := newPruner()
+			if err != nil {
+				return err
+			}
+
+			ctx, stop := notifyContext(cmd.Context())
+			defer stop()
+
+			res, err := p.PruneSnapshots(ctx, int(snapshotKeep), snapshotDryRun)
+			if err != nil && err != ctx.Err() {
+				return err
+			}
+
+			verb := "deleted"
+			if snapshotDryRun {
+				verb = "would delete"
+			}
+			for _, s := range res.Deleted {
+				fmt.Printf("%s snapshot at height %d format %d, %s\n", verb, s.Height, s.Format, dbutil.FormatBytes(s.Size))
+			}
+			for _, dir := range res.Orphaned {
+				fmt.Printf("%s orphaned chunk directory %s\n", verb, dir)
+			}
+			if ctx.Err() != nil {
+				fmt.Printf("%s, %d snapshots and %d orphaned chunk directories deleted\n",
+					pruner.StopReason(ctx), len(res.Deleted), len(res.Orphaned))
+				return interruptedError()
+			}
+
+			corrupt := 0
+			for _, s := range res.Kept {
+				err := p.CheckSnapshot(ctx, s)
+				if ctx.Err() != nil {
+					return interruptedError()
+				}
+				if err != nil {
+					fmt.Printf("snapshot at height %d format %d is corrupt: %s\n", s.Height, s.Format, err)
+					corrupt++
+					continue
+				}
+				fmt.Printf("checked snapshot at height %d format %d: %d chunks\n", s.Height, s.Format, s.Chunks)
+			}
+
+			if corrupt > 0 {
+				return fmt.Errorf("found %d corrupt snapshots", corrupt)
+			}
+
+			return nil
+		},
+	}
+
+	// --keep flag
+	cmd.Flags().UintVar(&snapshotKeep, "keep", 2, "set the amount of latest snapshot heights to be kept")
+	// --dry-run flag
+	cmd.Flags().BoolVar(&snapshotDryRun, "dry-run", false, "only report what would be deleted and check the snapshots kept")
+
+	return cmd
+}
+
+func snapshotVerifyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "verify [height]",
+		Short: "restore a local snapshot, the latest by default, and compare its state to the app hash at its height",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, err := newPruner()
+			if err != nil {
+				return err
+			}
+
+			list, err := p.Snapshots(cmd.Context())
+			if err != nil {
+				return err
+			}
+			if len(list) == 0 {
+				return fmt.Errorf("there are no snapshots in %s", filepath.Join(rootify(dataDir, homePath), "snapshots"))
+			}
+
+			height := list[len(list)-1].Height
+			if len(args) > 0 {
+				if height, err = strconv.ParseInt(args[0], 10, 64); err != nil {
+					return fmt.Errorf("invalid height %q: %w", args[0], err)
+				}
+			}
+			// all formats of the height
+			verify := make([]pruner.Snapshot, 0)
+			for _, s := range list {
+				if s.Height == height {
+					verify = append(verify, s)
+				}
+			}
+			if len(verify) == 0 {
+				return fmt.Errorf("there is no snapshot at height %d", height)
+			}
+
+			ctx, stop := notifyContext(cmd.Context())
+			defer stop()
+
+			for _, s := range verify {
+				fmt.Printf("restoring snapshot at height %d format %d: %d chunks, %s\n",
+					s.Height, s.Format, s.Chunks, dbutil.FormatBytes(s.Size))
+				res, err := p.VerifySnapshot(ctx, s, snapshotTempDir)
+				if ctx.Err() != nil {
+					return interruptedError()
+				}
+				if err != nil {
+					return err
+				}
+
+				fmt.Printf("restored state hash: %X\n", res.Hash)
+				if res.CommitInfoHash != nil {
+					fmt.Printf("matches the commit info at height %d\n", s.Height)
+				}
+				if res.AppHash != nil {
+					fmt.Printf("matches the app hash of block %d\n", s.Height+1)
+				}
+			}
+
+			return nil
+		},
+	}
+
+	// --temp-dir flag
+	cmd.Flags().StringVar(&snapshotTempDir, "temp-dir", "", "restore into a temporary db in this directory instead of memory, for states larger than the memory")
+
+	return cmd
+}
+
+// printSnapshots prints a table of snapshots
+func printSnapshots(list []pruner.Snapshot) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HEIGHT\tFORMAT\tCHUNKS\tSIZE\tHASH")
+	for _, s := range list {
+		fmt.Fprintf(w, "%d\t%d\t%d\t%s\t%X\n", s.Height, s.Format, s.Chunks, dbutil.FormatBytes(s.Size), s.Hash)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/dbutil"
+)
+
+func statusCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "show the versions and blocks to be pruned and the size of every db without modifying any data",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, err := resolveSettings(cmd); err != nil {
+				return err
+			}
+			p, err := newPruner()
+			if err != nil {
+				return err
+			}
+
+			status, err := p.Status(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+			if cosmosSdk {
+				fmt.Fprintf(w, "latest version: %d\n\n", status.LatestVersion)
+				fmt.Fprintln(w, "STORE\tVERSIONS\tFIRST\tLATEST\tTO PRUNE")
+				for _, s := range status.Stores {
+					fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\n", s.Name, s.Versions, s.First, s.Latest, s.ToPrune)
+				}
+				fmt.Fprintln(w)
+			}
+
+			if tendermint && status.BlockHeight > 0 {
+				fmt.Fprintf(w, "blocks: %d-%d", status.BlockBase, status.BlockHeight)
+				if status.PruneHeight > 0 {
+					fmt.Fprintf(w, ", pruned below %d", status.PruneHeight)
+				}
+				fmt.Fprint(w, "\n\n")
+			}
+
+			fmt.Fprintln(w, "DB\tSIZE")
+			for _, d := range status.DBs {
+				fmt.Fprintf(w, "%s\t%s\n", d.Name, dbutil.FormatBytes(d.Size))
+			}
+
+			return w.Flush()
+		},
+	}
+
+	return cmd
+}
@@ -0,0 +1,141 @@
+// Package dbutil has the helpers shared by the commands and the pruner package to work with the
+// databases on disk: key ranges, sizes and free space.
+package dbutil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+	db "github.com/tendermint/tm-db"
+)
+
+// KeyRange is a [Start, End) range of keys, nil means unbounded
+type KeyRange struct {
+	Name  string
+	Start []byte
+	End   []byte
+}
+
+// PrefixEnd returns the first key after all the keys starting with prefix
+func PrefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+
+	return nil
+}
+
+// SplitRanges returns the ranges between consecutive boundaries, covering the whole keyspace
+func SplitRanges(boundaries []string) []KeyRange {
+	sort.Strings(boundaries)
+
+	ranges := make([]KeyRange, 0, len(boundaries)+1)
+	var start []byte
+	name := "<start>"
+	for _, b := range boundaries {
+		ranges = append(ranges, KeyRange{Name: name, Start: start, End: []byte(b)})
+		start, name = []byte(b), b
+	}
+
+	return append(ranges, KeyRange{Name: name, Start: start})
+}
+
+// RangeSize returns the approximate size on disk of the ranges, using the goleveldb size
+// approximation if possible or else the size of the keys and values in the ranges
+func RangeSize(d db.DB, ranges []KeyRange) (int64, error) {
+	if gdb, ok := d.(*db.GoLevelDB); ok {
+		rs := make([]util.Range, 0, len(ranges))
+		for _, r := range ranges {
+			rs = append(rs, util.Range{Start: r.Start, Limit: r.End})
+		}
+		sizes, err := gdb.DB().SizeOf(rs)
+		if err != nil {
+			return 0, err
+		}
+		return sizes.Sum(), nil
+	}
+
+	var size int64
+	for _, r := range ranges {
+		itr, err := d.Iterator(r.Start, r.End)
+		if err != nil {
+			return 0, err
+		}
+		for ; itr.Valid(); itr.Next() {
+			size += int64(len(itr.Key()) + len(itr.Value()))
+		}
+		if err := itr.Close(); err != nil {
+			return 0, err
+		}
+	}
+
+	return size, nil
+}
+
+// DirSize returns the total size of the files inside path
+func DirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+
+	return size, err
+}
+
+// ParseBytes parses a size like 512, 64KiB, 64MiB, 1GiB or 100MB into bytes
+func ParseBytes(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		size   int64
+	}{
+		{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+		{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12}, {"B", 1},
+	}
+
+	num := strings.TrimSpace(s)
+	multiplier := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(num, u.suffix) {
+			num, multiplier = strings.TrimSpace(strings.TrimSuffix(num, u.suffix)), u.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	return int64(n * float64(multiplier)), nil
+}
+
+// FormatBytes prints a byte count in a human readable unit
+func FormatBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.2f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,29 @@
+//go:build !windows
+// +build !windows
+
+package dbutil
+
+import (
+	"strconv"
+	"syscall"
+)
+
+// FreeSpace returns the bytes available to the user on the filesystem of path
+func FreeSpace(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
+
+// Filesystem returns an identifier of the filesystem of path, the same for all paths on it
+func Filesystem(path string) (string, error) {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		return "", err
+	}
+
+	return strconv.FormatUint(uint64(stat.Dev), 10), nil
+}
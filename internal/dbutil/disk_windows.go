@@ -0,0 +1,40 @@
+//go:build windows
+// +build windows
+
+package dbutil
+
+import (
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// FreeSpace returns the bytes available to the user on the filesystem of path
+func FreeSpace(path string) (int64, error) {
+	kernel32 := syscall.NewLazyDLL("kernel32.dll")
+	getDiskFreeSpaceEx := kernel32.NewProc("GetDiskFreeSpaceExW")
+
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var free int64
+	r, _, err := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&free)), 0, 0)
+	if r == 0 {
+		return 0, err
+	}
+
+	return free, nil
+}
+
+// Filesystem returns an identifier of the filesystem of path, the same for all paths on it
+func Filesystem(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.ToUpper(filepath.VolumeName(abs)), nil
+}
@@ -0,0 +1,169 @@
+package iavldb
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+// Problem is an inconsistency found in the records of a tree.
+type Problem struct {
+	Version int64  `json:"version"`
+	Kind    string `json:"kind"`
+	Detail  string `json:"detail"`
+
+	// Key is the key of the record the problem was found at
+	Key []byte `json:"-"`
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("version %d: %s: %s", p.Version, p.Kind, p.Detail)
+}
+
+const (
+	ProblemMissingNode    = "missing node"
+	ProblemCorruptNode    = "corrupt node"
+	ProblemHashMismatch   = "hash mismatch"
+	ProblemDanglingOrphan = "dangling orphan"
+	ProblemOrphanNoNode   = "orphan without node"
+)
+
+// Roots returns the sorted versions that have a root record, and their root hashes.
+func Roots(db dbm.DB) ([]int64, map[int64][]byte, error) {
+	itr, err := dbm.IteratePrefix(db, RootKeyFormat.Key())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer itr.Close()
+
+	versions := make([]int64, 0)
+	roots := make(map[int64][]byte)
+	for ; itr.Valid(); itr.Next() {
+		version := ParseRootKey(itr.Key())
+		versions = append(versions, version)
+		roots[version] = append([]byte{}, itr.Value()...)
+	}
+
+	return versions, roots, itr.Error()
+}
+
+// Checker walks the trees of a store. Nodes are shared between versions, so the nodes verified
+// once are remembered and their subtrees are not walked again.
+type Checker struct {
+	db       dbm.DB
+	verified map[string]struct{}
+
+	// Nodes is the amount of nodes read and hashed
+	Nodes int64
+}
+
+// NewChecker returns a Checker reading the records of a single tree from db.
+func NewChecker(db dbm.DB) *Checker {
+	return &Checker{
+		db:       db,
+		verified: make(map[string]struct{}),
+	}
+}
+
+// CheckTree walks the tree of version from rootHash and checks that every node it refers to
+// exists, can be decoded and has the hash it is referred by.
+func (c *Checker) CheckTree(version int64, rootHash []byte) ([]Problem, error) {
+	problems := make([]Problem, 0)
+	if len(rootHash) == 0 {
+		// empty tree
+		return problems, nil
+	}
+
+	_, err := c.checkNode(version, rootHash, &problems)
+	return problems, err
+}
+
+// checkNode checks the subtree of the node with hash, a node is only remembered as verified once
+// its whole subtree is, so that every version sharing a broken subtree reports it
+func (c *Checker) checkNode(version int64, hash []byte, problems *[]Problem) (bool, error) {
+	if _, ok := c.verified[string(hash)]; ok {
+		return true, nil
+	}
+
+	bz, err := c.db.Get(NodeKey(hash))
+	if err != nil {
+		return false, err
+	}
+	if bz == nil {
+		*problems = append(*problems, Problem{version, ProblemMissingNode, fmt.Sprintf("%X", hash), NodeKey(hash)})
+		return false, nil
+	}
+
+	node, err := DecodeNode(bz)
+	if err != nil {
+		*problems = append(*problems, Problem{version, ProblemCorruptNode, fmt.Sprintf("%X: %v", hash, err), NodeKey(hash)})
+		return false, nil
+	}
+	c.Nodes++
+
+	if h := node.Hash(); !bytes.Equal(h, hash) {
+		*problems = append(*problems, Problem{version, ProblemHashMismatch, fmt.Sprintf("%X hashes to %X", hash, h), NodeKey(hash)})
+		return false, nil
+	}
+
+	if !node.IsLeaf() {
+		leftOK, err := c.checkNode(version, node.LeftHash, problems)
+		if err != nil {
+			return false, err
+		}
+		rightOK, err := c.checkNode(version, node.RightHash, problems)
+		if err != nil || !leftOK || !rightOK {
+			return false, err
+		}
+	}
+	c.verified[string(hash)] = struct{}{}
+
+	return true, nil
+}
+
+// Verified returns whether the node with hash was verified as part of a tree walked before.
+func (c *Checker) Verified(hash []byte) bool {
+	_, ok := c.verified[string(hash)]
+	return ok
+}
+
+// CheckOrphans checks the orphan records against the sorted versions that still exist. An orphan
+// is dangling if no existing version falls in its lifetime, as it should have been deleted along
+// with its node when the last of these versions was deleted.
+func CheckOrphans(db dbm.DB, versions []int64) ([]Problem, error) {
+	itr, err := dbm.IteratePrefix(db, OrphanKeyFormat.Key())
+	if err != nil {
+		return nil, err
+	}
+	defer itr.Close()
+
+	problems := make([]Problem, 0)
+	for ; itr.Valid(); itr.Next() {
+		toVersion, fromVersion, hash := ParseOrphanKey(itr.Key())
+
+		if !HasVersionIn(versions, fromVersion, toVersion) {
+			problems = append(problems, Problem{toVersion, ProblemDanglingOrphan,
+				fmt.Sprintf("%X lives from %d to %d", hash, fromVersion, toVersion), itr.Key()})
+			continue
+		}
+
+		ok, err := db.Has(NodeKey(hash))
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			problems = append(problems, Problem{toVersion, ProblemOrphanNoNode,
+				fmt.Sprintf("%X lives from %d to %d", hash, fromVersion, toVersion), itr.Key()})
+		}
+	}
+
+	return problems, itr.Error()
+}
+
+// HasVersionIn returns whether one of the sorted versions is in [from, to].
+func HasVersionIn(versions []int64, from, to int64) bool {
+	i := sort.Search(len(versions), func(i int) bool { return versions[i] >= from })
+	return i < len(versions) && versions[i] <= to
+}
@@ -0,0 +1,114 @@
+package iavldb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cosmos/iavl"
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func newTestTree(t *testing.T, db dbm.DB, versions int) *iavl.MutableTree {
+	tree, err := iavl.NewMutableTree(db, 0)
+	require.NoError(t, err)
+
+	for v := 1; v <= versions; v++ {
+		for i := 0; i < 10; i++ {
+			tree.Set([]byte(fmt.Sprintf("key%d", (v*3+i)%25)), []byte(fmt.Sprintf("value%d-%d", v, i)))
+		}
+		_, _, err := tree.SaveVersion()
+		require.NoError(t, err)
+	}
+
+	return tree
+}
+
+func TestDecodeNodeHash(t *testing.T) {
+	db := dbm.NewMemDB()
+	newTestTree(t, db, 5)
+
+	itr, err := dbm.IteratePrefix(db, NodeKeyFormat.Key())
+	require.NoError(t, err)
+	defer itr.Close()
+
+	n := 0
+	for ; itr.Valid(); itr.Next() {
+		node, err := DecodeNode(itr.Value())
+		require.NoError(t, err)
+		require.Equal(t, itr.Key()[1:], node.Hash())
+		n++
+	}
+	require.NotZero(t, n)
+}
+
+func TestCheckTree(t *testing.T) {
+	db := dbm.NewMemDB()
+	tree := newTestTree(t, db, 10)
+	require.NoError(t, tree.DeleteVersions(2, 3, 4))
+
+	versions, roots, err := Roots(db)
+	require.NoError(t, err)
+	require.Equal(t, []int64{1, 5, 6, 7, 8, 9, 10}, versions)
+
+	c := NewChecker(db)
+	for _, v := range versions {
+		problems, err := c.CheckTree(v, roots[v])
+		require.NoError(t, err)
+		require.Empty(t, problems)
+	}
+
+	problems, err := CheckOrphans(db, versions)
+	require.NoError(t, err)
+	require.Empty(t, problems)
+
+	// delete a node of the latest tree
+	require.NoError(t, db.Delete(NodeKey(roots[10])))
+	problems, err = NewChecker(db).CheckTree(10, roots[10])
+	require.NoError(t, err)
+	require.Len(t, problems, 1)
+	require.Equal(t, ProblemMissingNode, problems[0].Kind)
+
+	// drop the roots of versions still referenced by orphans
+	require.NoError(t, db.Delete(RootKey(1)))
+	problems, err = CheckOrphans(db, versions[1:])
+	require.NoError(t, err)
+	require.NotEmpty(t, problems)
+	for _, p := range problems {
+		require.Equal(t, ProblemDanglingOrphan, p.Kind)
+	}
+}
+
+func TestCheckTreeSharedNode(t *testing.T) {
+	db := dbm.NewMemDB()
+	newTestTree(t, db, 10)
+
+	versions, roots, err := Roots(db)
+	require.NoError(t, err)
+
+	// pick a node that lives in more than one version
+	itr, err := dbm.IteratePrefix(db, OrphanKeyFormat.Key())
+	require.NoError(t, err)
+	var from, to int64
+	var hash []byte
+	for ; itr.Valid(); itr.Next() {
+		if to, from, hash = ParseOrphanKey(itr.Key()); to > from {
+			break
+		}
+	}
+	require.NoError(t, itr.Close())
+	require.Greater(t, to, from)
+	require.NoError(t, db.Delete(NodeKey(hash)))
+
+	// every version sharing the node reports it, not only the first one walked
+	c := NewChecker(db)
+	for _, v := range versions {
+		problems, err := c.CheckTree(v, roots[v])
+		require.NoError(t, err)
+		if v >= from && v <= to {
+			require.NotEmpty(t, problems, "version %d", v)
+		} else {
+			require.Empty(t, problems, "version %d", v)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// Package iavldb reads the records an IAVL tree keeps in its database, without loading the tree.
+//
+// The layout follows github.com/cosmos/iavl nodedb.go:
+//
+//	n<hash>                            node
+//	o<toVersion><fromVersion><hash>    orphan, the node with hash lives from fromVersion to toVersion
+//	r<version>                         root hash of version
+package iavldb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/cosmos/iavl"
+	dbm "github.com/tendermint/tm-db"
+)
+
+const (
+	hashSize  = sha256.Size
+	int64Size = 8
+)
+
+var (
+	NodeKeyFormat   = iavl.NewKeyFormat('n', hashSize)                       // n<hash>
+	OrphanKeyFormat = iavl.NewKeyFormat('o', int64Size, int64Size, hashSize) // o<last-version><first-version><hash>
+	RootKeyFormat   = iavl.NewKeyFormat('r', int64Size)                      // r<version>
+)
+
+// NodeKey returns the key of the node with the given hash.
+func NodeKey(hash []byte) []byte {
+	return NodeKeyFormat.KeyBytes(hash)
+}
+
+// OrphanKey returns the key of the orphan record of the node with the given hash.
+func OrphanKey(toVersion, fromVersion int64, hash []byte) []byte {
+	return OrphanKeyFormat.Key(toVersion, fromVersion, hash)
+}
+
+// RootKey returns the key of the root record of version.
+func RootKey(version int64) []byte {
+	return RootKeyFormat.Key(version)
+}
+
+// ParseOrphanKey returns the lifetime and the node hash of an orphan key.
+func ParseOrphanKey(key []byte) (toVersion, fromVersion int64, hash []byte) {
+	OrphanKeyFormat.Scan(key, &toVersion, &fromVersion, &hash)
+	return toVersion, fromVersion, hash
+}
+
+// ParseRootKey returns the version of a root key.
+func ParseRootKey(key []byte) (version int64) {
+	RootKeyFormat.Scan(key, &version)
+	return version
+}
+
+// Node is a decoded IAVL node. Leaves have a Value, inner nodes have a LeftHash and a RightHash.
+type Node struct {
+	Height    int8
+	Size      int64
+	Version   int64
+	Key       []byte
+	Value     []byte
+	LeftHash  []byte
+	RightHash []byte
+}
+
+// IsLeaf returns whether the node is a leaf.
+func (n *Node) IsLeaf() bool {
+	return n.Height == 0
+}
+
+// DecodeNode decodes a node as written by iavl Node.writeBytes.
+func DecodeNode(buf []byte) (*Node, error) {
+	height, buf, err := decodeVarint(buf)
+	if err != nil {
+		return nil, fmt.Errorf("decoding node.height: %w", err)
+	}
+	if height < -128 || height > 127 {
+		return nil, errors.New("invalid height, must be int8")
+	}
+
+	n := &Node{Height: int8(height)}
+	if n.Size, buf, err = decodeVarint(buf); err != nil {
+		return nil, fmt.Errorf("decoding node.size: %w", err)
+	}
+	if n.Version, buf, err = decodeVarint(buf); err != nil {
+		return nil, fmt.Errorf("decoding node.version: %w", err)
+	}
+	if n.Key, buf, err = decodeBytes(buf); err != nil {
+		return nil, fmt.Errorf("decoding node.key: %w", err)
+	}
+
+	if n.IsLeaf() {
+		if n.Value, _, err = decodeBytes(buf); err != nil {
+			return nil, fmt.Errorf("decoding node.value: %w", err)
+		}
+		return n, nil
+	}
+
+	if n.LeftHash, buf, err = decodeBytes(buf); err != nil {
+		return nil, fmt.Errorf("decoding node.leftHash: %w", err)
+	}
+	if n.RightHash, _, err = decodeBytes(buf); err != nil {
+		return nil, fmt.Errorf("decoding node.rightHash: %w", err)
+	}
+
+	return n, nil
+}
+
+// Hash computes the hash of the node as iavl Node.writeHashBytes does.
+func (n *Node) Hash() []byte {
+	var buf bytes.Buffer
+	encodeVarint(&buf, int64(n.Height))
+	encodeVarint(&buf, n.Size)
+	encodeVarint(&buf, n.Version)
+
+	if n.IsLeaf() {
+		// Key is not written for inner nodes
+		encodeBytes(&buf, n.Key)
+		valueHash := sha256.Sum256(n.Value)
+		encodeBytes(&buf, valueHash[:])
+	} else {
+		encodeBytes(&buf, n.LeftHash)
+		encodeBytes(&buf, n.RightHash)
+	}
+
+	hash := sha256.Sum256(buf.Bytes())
+	return hash[:]
+}
+
+func decodeVarint(buf []byte) (int64, []byte, error) {
+	i, n := binary.Varint(buf)
+	if n == 0 {
+		return 0, nil, errors.New("buffer too small")
+	} else if n < 0 {
+		return 0, nil, errors.New("EOF decoding varint")
+	}
+	return i, buf[n:], nil
+}
+
+func decodeBytes(buf []byte) ([]byte, []byte, error) {
+	size, n := binary.Uvarint(buf)
+	if n == 0 {
+		return nil, nil, errors.New("buffer too small")
+	} else if n < 0 {
+		return nil, nil, errors.New("EOF decoding uvarint")
+	}
+	if size > uint64(len(buf)-n) {
+		return nil, nil, fmt.Errorf("insufficient bytes decoding []byte of length %v", size)
+	}
+	end := n + int(size)
+	return buf[n:end], buf[end:], nil
+}
+
+func encodeVarint(buf *bytes.Buffer, i int64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutVarint(b[:], i)])
+}
+
+func encodeBytes(buf *bytes.Buffer, bz []byte) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutUvarint(b[:], uint64(len(bz)))])
+	buf.Write(bz)
+}
+
+// DeleteVersion deletes version from the tree the way iavl DeleteVersionsRange does: every orphan
+// whose lifetime ends at version is deleted along with its node if the node was created after
+// predecessor, the last version kept before version, otherwise the orphan is moved to end at
+// predecessor. The root record of version is deleted last. It returns the amount of deleted nodes.
+func DeleteVersion(db dbm.DB, batch dbm.Batch, version, predecessor int64) (int, error) {
+	return DeleteVersions(db, batch, version, version+1, predecessor)
+}
+
+// DeleteVersions deletes the versions in [fromVersion, toVersion) like DeleteVersion, sweeping
+// the orphan and root records of the whole range at once.
+func DeleteVersions(db dbm.DB, batch dbm.Batch, fromVersion, toVersion, predecessor int64) (int, error) {
+	itr, err := db.Iterator(OrphanKeyFormat.Key(fromVersion), OrphanKeyFormat.Key(toVersion))
+	if err != nil {
+		return 0, err
+	}
+	defer itr.Close()
+
+	deleted := 0
+	for ; itr.Valid(); itr.Next() {
+		_, fromOrphan, hash := ParseOrphanKey(itr.Key())
+
+		if err := batch.Delete(itr.Key()); err != nil {
+			return deleted, err
+		}
+		if fromOrphan > predecessor {
+			if err := batch.Delete(NodeKey(hash)); err != nil {
+				return deleted, err
+			}
+			deleted++
+		} else {
+			if err := batch.Set(OrphanKey(predecessor, fromOrphan, hash), hash); err != nil {
+				return deleted, err
+			}
+		}
+	}
+	if err := itr.Error(); err != nil {
+		return deleted, err
+	}
+
+	roots, err := db.Iterator(RootKey(fromVersion), RootKey(toVersion))
+	if err != nil {
+		return deleted, err
+	}
+	defer roots.Close()
+
+	for ; roots.Valid(); roots.Next() {
+		if err := batch.Delete(roots.Key()); err != nil {
+			return deleted, err
+		}
+	}
+
+	return deleted, roots.Error()
+}
@@ -0,0 +1,44 @@
+package iavldb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func requireEqualDB(t *testing.T, expected, actual dbm.DB) {
+	expItr, err := expected.Iterator(nil, nil)
+	require.NoError(t, err)
+	defer expItr.Close()
+	actItr, err := actual.Iterator(nil, nil)
+	require.NoError(t, err)
+	defer actItr.Close()
+
+	for ; expItr.Valid(); expItr.Next() {
+		require.True(t, actItr.Valid(), "missing key %X", expItr.Key())
+		require.Equal(t, expItr.Key(), actItr.Key())
+		require.Equal(t, expItr.Value(), actItr.Value())
+		actItr.Next()
+	}
+	require.False(t, actItr.Valid(), "unexpected keys")
+}
+
+func TestDeleteVersion(t *testing.T) {
+	expected := dbm.NewMemDB()
+	tree := newTestTree(t, expected, 10)
+	require.NoError(t, tree.DeleteVersions(3, 4, 7))
+
+	actual := dbm.NewMemDB()
+	newTestTree(t, actual, 10)
+
+	for _, v := range [][2]int64{{3, 2}, {4, 2}, {7, 6}} {
+		batch := actual.NewBatch()
+		_, err := DeleteVersion(actual, batch, v[0], v[1])
+		require.NoError(t, err)
+		require.NoError(t, batch.Write())
+		require.NoError(t, batch.Close())
+	}
+
+	requireEqualDB(t, expected, actual)
+}
@@ -0,0 +1,122 @@
+package iavldb
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/neilotoole/errgroup"
+	dbm "github.com/tendermint/tm-db"
+)
+
+// Range is a range of versions [From, To) deleted together. Predecessor is the last version kept
+// before From, or 0 if there is none.
+type Range struct {
+	From, To    int64
+	Predecessor int64
+}
+
+// DeleteRanges splits the sorted heights to be deleted into the ranges iavl DeleteVersions would
+// delete them in. Heights that follow each other in the sorted existing versions are deleted
+// together, so that every range has a kept predecessor.
+func DeleteRanges(versions, heights []int64) []Range {
+	deleted := make(map[int64]bool, len(heights))
+	for _, h := range heights {
+		deleted[h] = true
+	}
+
+	ranges := make([]Range, 0)
+	predecessor := int64(0)
+	for i := 0; i < len(versions); i++ {
+		if !deleted[versions[i]] {
+			predecessor = versions[i]
+			continue
+		}
+
+		j := i
+		for j+1 < len(versions) && deleted[versions[j+1]] {
+			j++
+		}
+		ranges = append(ranges, Range{From: versions[i], To: versions[j] + 1, Predecessor: predecessor})
+		i = j
+	}
+
+	return ranges
+}
+
+// DeleteRange deletes the versions of r the way iavl DeleteVersionsRange does. Their orphans are
+// swept in parts of partSize versions by up to workers goroutines into a single batch, which is
+// only written once every part succeeded, so that the versions of r are either all deleted or all
+// left intact. This is correct because every orphan ending in r is either deleted or moved to end
+// at r.Predecessor on its own, regardless of the other orphans. It returns the amount of deleted
+// nodes.
+func DeleteRange(ctx context.Context, db dbm.DB, r Range, partSize int64, workers int) (int, error) {
+	if partSize <= 0 {
+		partSize = r.To - r.From
+	}
+
+	batch := &lockedBatch{Batch: db.NewBatch()}
+	defer batch.Close()
+
+	// every part is queued at once, workers are only started for a queue that is not empty
+	parts := int((r.To - r.From + partSize - 1) / partSize)
+	var deleted int64
+	errs, ctx := errgroup.WithContextN(ctx, workers, parts)
+	for from := r.From; from < r.To; from += partSize {
+		from, to := from, from+partSize
+		if to > r.To {
+			to = r.To
+		}
+
+		errs.Go(func() error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			n, err := DeleteVersions(db, batch, from, to, r.Predecessor)
+			atomic.AddInt64(&deleted, int64(n))
+			return err
+		})
+	}
+	if err := errs.Wait(); err != nil {
+		return 0, err
+	}
+
+	return int(deleted), batch.Write()
+}
+
+// lockedBatch is a batch several goroutines can add to at once
+type lockedBatch struct {
+	mtx sync.Mutex
+	dbm.Batch
+}
+
+func (b *lockedBatch) Set(key, value []byte) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.Batch.Set(key, value)
+}
+
+func (b *lockedBatch) Delete(key []byte) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.Batch.Delete(key)
+}
+
+// DeleteSize returns about the amount of bytes written to delete the versions in
+// [fromVersion, toVersion): every orphan ending in it is deleted, and either its node is deleted or
+// it is moved.
+func DeleteSize(db dbm.DB, fromVersion, toVersion int64) (int64, error) {
+	itr, err := db.Iterator(OrphanKeyFormat.Key(fromVersion), OrphanKeyFormat.Key(toVersion))
+	if err != nil {
+		return 0, err
+	}
+	defer itr.Close()
+
+	size := int64(0)
+	for ; itr.Valid(); itr.Next() {
+		size += int64(2*len(itr.Key()) + len(itr.Value()))
+	}
+
+	return size, itr.Error()
+}
@@ -0,0 +1,118 @@
+package iavldb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cosmos/iavl"
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func TestDeleteRanges(t *testing.T) {
+	versions := []int64{1, 2, 3, 5, 6, 8, 9, 10}
+
+	require.Equal(t, []Range{{1, 4, 0}, {6, 7, 5}, {9, 10, 8}},
+		DeleteRanges(versions, []int64{1, 2, 3, 6, 9}))
+	// a gap in the versions does not split a range
+	require.Equal(t, []Range{{2, 7, 1}}, DeleteRanges(versions, []int64{2, 3, 5, 6}))
+	require.Empty(t, DeleteRanges(versions, nil))
+}
+
+func TestDeleteRange(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		heights []int64
+	}{
+		{"contiguous", []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23}},
+		{"keep every 5", []int64{1, 2, 3, 4, 6, 7, 8, 9, 11, 12, 13, 14, 16, 17, 18, 19, 21, 22, 23, 24}},
+		{"kept first", []int64{2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// pruned sequentially by iavl
+			expected := dbm.NewMemDB()
+			tree := newTestTree(t, expected, 30)
+			require.NoError(t, tree.DeleteVersions(tc.heights...))
+
+			actual := dbm.NewMemDB()
+			newTestTree(t, actual, 30)
+
+			versions, _, err := Roots(actual)
+			require.NoError(t, err)
+			for _, r := range DeleteRanges(versions, tc.heights) {
+				_, err := DeleteRange(context.Background(), actual, r, 2, 4)
+				require.NoError(t, err)
+			}
+
+			requireEqualDB(t, expected, actual)
+		})
+	}
+}
+
+// failingDB fails to iterate the orphans from version on
+type failingDB struct {
+	dbm.DB
+	version int64
+}
+
+func (d failingDB) Iterator(start, end []byte) (dbm.Iterator, error) {
+	if bytes.Equal(start, OrphanKeyFormat.Key(d.version)) {
+		return nil, errors.New("disk failure")
+	}
+	return d.DB.Iterator(start, end)
+}
+
+func TestDeleteRangeFailure(t *testing.T) {
+	expected := dbm.NewMemDB()
+	newTestTree(t, expected, 30)
+
+	actual := dbm.NewMemDB()
+	newTestTree(t, actual, 30)
+
+	// the part of versions 5-6 fails while the parts before and after it succeed
+	_, err := DeleteRange(context.Background(), failingDB{actual, 5}, Range{From: 1, To: 11}, 2, 4)
+	require.EqualError(t, err, "disk failure")
+
+	// nothing was written, every version still loads
+	requireEqualDB(t, expected, actual)
+	versions, roots, err := Roots(actual)
+	require.NoError(t, err)
+	require.Len(t, versions, 30)
+	c := NewChecker(actual)
+	for _, v := range versions {
+		problems, err := c.CheckTree(v, roots[v])
+		require.NoError(t, err)
+		require.Empty(t, problems)
+	}
+	tree, err := iavl.NewMutableTree(actual, 0)
+	require.NoError(t, err)
+	_, err = tree.LoadVersion(30)
+	require.NoError(t, err)
+
+	// and the range can be deleted again
+	_, err = DeleteRange(context.Background(), actual, Range{From: 1, To: 11}, 2, 4)
+	require.NoError(t, err)
+	versions, _, err = Roots(actual)
+	require.NoError(t, err)
+	require.Len(t, versions, 20)
+}
+
+func TestDeleteSize(t *testing.T) {
+	db := dbm.NewMemDB()
+	newTestTree(t, db, 10)
+
+	first, err := DeleteSize(db, 1, 2)
+	require.NoError(t, err)
+	require.Positive(t, first)
+
+	all, err := DeleteSize(db, 1, 10)
+	require.NoError(t, err)
+	require.Greater(t, all, first)
+
+	// the latest version has no orphans yet
+	latest, err := DeleteSize(db, 10, 11)
+	require.NoError(t, err)
+	require.Zero(t, latest)
+}
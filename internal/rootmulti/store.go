@@ -156,7 +156,10 @@ func (rs *Store) GetCommitKVStore(key types.StoreKey) types.CommitKVStore {
 
 // LoadLatestVersionAndUpgrade implements CommitMultiStore
 func (rs *Store) LoadLatestVersionAndUpgrade(upgrades *types.StoreUpgrades) error {
-	ver := GetLatestVersion(rs.db)
+	ver, err := GetLatestVersion(rs.db)
+	if err != nil {
+		return err
+	}
 	return rs.loadVersion(ver, upgrades)
 }
 
@@ -167,7 +170,10 @@ func (rs *Store) LoadVersionAndUpgrade(ver int64, upgrades *types.StoreUpgrades)
 
 // LoadLatestVersion implements CommitMultiStore.
 func (rs *Store) LoadLatestVersion() error {
-	ver := GetLatestVersion(rs.db)
+	ver, err := GetLatestVersion(rs.db)
+	if err != nil {
+		return err
+	}
 	return rs.loadVersion(ver, nil)
 }
 
@@ -365,8 +371,12 @@ func (rs *Store) ListeningEnabled(key types.StoreKey) bool {
 // LastCommitID implements Committer/CommitStore.
 func (rs *Store) LastCommitID() types.CommitID {
 	if rs.lastCommitInfo == nil {
+		ver, err := GetLatestVersion(rs.db)
+		if err != nil {
+			panic(err)
+		}
 		return types.CommitID{
-			Version: GetLatestVersion(rs.db),
+			Version: ver,
 		}
 	}
 
@@ -420,10 +430,14 @@ func (rs *Store) Commit() types.CommitID {
 
 	// batch prune if the current height is a pruning interval height
 	if rs.pruningOpts.Interval > 0 && version%int64(rs.pruningOpts.Interval) == 0 {
-		rs.PruneStores(len(rs.PruneHeights))
+		if err := rs.PruneStores(len(rs.PruneHeights)); err != nil {
+			panic(err)
+		}
 	}
 
-	flushMetadata(rs.db, version, rs.lastCommitInfo, rs.PruneHeights)
+	if err := flushMetadata(rs.db, version, rs.lastCommitInfo, rs.PruneHeights); err != nil {
+		panic(err)
+	}
 
 	return types.CommitID{
 		Version: version,
@@ -433,41 +447,93 @@ func (rs *Store) Commit() types.CommitID {
 
 type empty struct{}
 
+// PruneError is the failure to delete a batch of versions from a store.
+type PruneError struct {
+	Store string
+	// From and To are the first and last version of the batch
+	From, To int64
+	Err      error
+}
+
+func (e *PruneError) Error() string {
+	return fmt.Sprintf("failed to prune store %s versions %d-%d: %v", e.Store, e.From, e.To, e.Err)
+}
+
+func (e *PruneError) Unwrap() error {
+	return e.Err
+}
+
+// PruneErrors are the failures of all the stores pruned together.
+type PruneErrors []*PruneError
+
+func (e PruneErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
 // PruneStores will batch delete a list of heights from each mounted sub-store.
-// Afterwards, pruneHeights is reset.
-func (rs *Store) PruneStores(batch int) {
+// Afterwards, pruneHeights is reset. A store stops being pruned at its first failed batch while
+// the other stores are still pruned, the failures of all stores are returned as PruneErrors.
+func (rs *Store) PruneStores(batch int) error {
 	if len(rs.PruneHeights) == 0 {
-		return
+		return nil
 	}
 
-	for key, store := range rs.stores {
-		if store.GetStoreType() == types.StoreTypeIAVL {
-			// If the store is wrapped with an inter-block cache, we must first unwrap
-			// it to get the underlying IAVL store.
-			store = rs.GetCommitKVStore(key)
+	var pruneErrs PruneErrors
+	for key := range rs.stores {
+		var pruneErr *PruneError
+		if err := rs.PruneStore(key, rs.PruneHeights, batch); errors.As(err, &pruneErr) {
+			pruneErrs = append(pruneErrs, pruneErr)
+		}
+	}
 
-			if batch == 0 {
-				batch = len(rs.PruneHeights)
-			}
-			for i := 0; i < len(rs.PruneHeights); i += batch {
-				j := i + batch
-				if j > len(rs.PruneHeights) {
-					j = len(rs.PruneHeights)
-				}
-				if err := store.(*iavl.Store).DeleteVersions(rs.PruneHeights[i:j]...); err != nil {
-					if errCause := errors.Cause(err); errCause != nil &&
-						errCause != iavltree.ErrVersionDoesNotExist {
-						fmt.Println("error pruning store:", key.Name())
-						if !strings.HasPrefix(err.Error(), "cannot delete latest saved version") {
-							panic(err)
-						}
+	rs.PruneHeights = make([]int64, 0)
+
+	if len(pruneErrs) > 0 {
+		return pruneErrs
+	}
+	return nil
+}
+
+// PruneStore batch deletes heights from the sub-store mounted with key, it stops at the first
+// failed batch and returns it as a *PruneError. Different stores can be pruned concurrently.
+func (rs *Store) PruneStore(key types.StoreKey, heights []int64, batch int) error {
+	store := rs.stores[key]
+	if store == nil || store.GetStoreType() != types.StoreTypeIAVL {
+		return nil
+	}
+
+	// If the store is wrapped with an inter-block cache, we must first unwrap
+	// it to get the underlying IAVL store.
+	store = rs.GetCommitKVStore(key)
+
+	if batch == 0 {
+		batch = len(heights)
+	}
+	for i := 0; i < len(heights); i += batch {
+		j := i + batch
+		if j > len(heights) {
+			j = len(heights)
+		}
+		if err := store.(*iavl.Store).DeleteVersions(heights[i:j]...); err != nil {
+			if errCause := errors.Cause(err); errCause != nil &&
+				errCause != iavltree.ErrVersionDoesNotExist {
+				if !strings.HasPrefix(err.Error(), "cannot delete latest saved version") {
+					return &PruneError{
+						Store: key.Name(),
+						From:  heights[i],
+						To:    heights[j-1],
+						Err:   err,
 					}
 				}
 			}
 		}
 	}
 
-	rs.PruneHeights = make([]int64, 0)
+	return nil
 }
 
 func (rs *Store) GetAllVersions() []int {
@@ -923,7 +989,9 @@ func (rs *Store) Restore(
 		importer.Close()
 	}
 
-	flushMetadata(rs.db, int64(height), rs.buildCommitInfo(int64(height)), []int64{})
+	if err := flushMetadata(rs.db, int64(height), rs.buildCommitInfo(int64(height)), []int64{}); err != nil {
+		return err
+	}
 	return rs.LoadLatestVersion()
 }
 
@@ -1015,21 +1083,22 @@ type storeParams struct {
 	initialVersion uint64
 }
 
-func GetLatestVersion(db dbm.DB) int64 {
+// GetLatestVersion returns the latest version committed to db, or 0 if there is none.
+func GetLatestVersion(db dbm.DB) (int64, error) {
 	bz, err := db.Get([]byte(latestVersionKey))
 	if err != nil {
-		panic(err)
+		return 0, fmt.Errorf("failed to get latest version: %w", err)
 	} else if bz == nil {
-		return 0
+		return 0, nil
 	}
 
 	var latestVersion int64
 
 	if err := gogotypes.StdInt64Unmarshal(&latestVersion, bz); err != nil {
-		panic(err)
+		return 0, fmt.Errorf("failed to decode latest version: %w", err)
 	}
 
-	return latestVersion
+	return latestVersion, nil
 }
 
 // Commits each store and returns a new commitInfo.
@@ -1061,6 +1130,36 @@ func commitStores(
 	}
 }
 
+// ErrCommitInfoNotFound is returned by GetCommitInfo when there is no commit info for the version.
+var ErrCommitInfoNotFound = errors.New("no commit info found")
+
+// GetCommitInfo returns the commitInfo stored on disk for the given version.
+func GetCommitInfo(db dbm.DB, ver int64) (*types.CommitInfo, error) {
+	return getCommitInfo(db, ver)
+}
+
+// GetStoreNames returns the sorted names of the stores in the commit info of the latest version.
+func GetStoreNames(db dbm.DB) ([]string, error) {
+	names := []string{}
+
+	ver, err := GetLatestVersion(db)
+	if err != nil {
+		return nil, err
+	}
+	if ver != 0 {
+		cInfo, err := getCommitInfo(db, ver)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range cInfo.StoreInfos {
+			names = append(names, info.Name)
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
 // Gets commitInfo from disk.
 func getCommitInfo(db dbm.DB, ver int64) (*types.CommitInfo, error) {
 	cInfoKey := fmt.Sprintf(commitInfoKeyFmt, ver)
@@ -1069,7 +1168,7 @@ func getCommitInfo(db dbm.DB, ver int64) (*types.CommitInfo, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get commit info")
 	} else if bz == nil {
-		return nil, errors.New("no commit info found")
+		return nil, ErrCommitInfoNotFound
 	}
 
 	cInfo := &types.CommitInfo{}
@@ -1080,23 +1179,23 @@ func getCommitInfo(db dbm.DB, ver int64) (*types.CommitInfo, error) {
 	return cInfo, nil
 }
 
-func setCommitInfo(batch dbm.Batch, version int64, cInfo *types.CommitInfo) {
+func setCommitInfo(batch dbm.Batch, version int64, cInfo *types.CommitInfo) error {
 	bz, err := cInfo.Marshal()
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("failed to encode commit info: %w", err)
 	}
 
 	cInfoKey := fmt.Sprintf(commitInfoKeyFmt, version)
-	batch.Set([]byte(cInfoKey), bz)
+	return batch.Set([]byte(cInfoKey), bz)
 }
 
-func setLatestVersion(batch dbm.Batch, version int64) {
+func setLatestVersion(batch dbm.Batch, version int64) error {
 	bz, err := gogotypes.StdInt64Marshal(version)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("failed to encode latest version: %w", err)
 	}
 
-	batch.Set([]byte(latestVersionKey), bz)
+	return batch.Set([]byte(latestVersionKey), bz)
 }
 
 func setPruningHeights(batch dbm.Batch, pruneHeights []int64) {
@@ -1110,6 +1209,30 @@ func setPruningHeights(batch dbm.Batch, pruneHeights []int64) {
 	batch.Set([]byte(pruneHeightsKey), bz)
 }
 
+// GetPruningHeights returns the heights stored on disk to be pruned on the next run, none if
+// there are none.
+func GetPruningHeights(db dbm.DB) ([]int64, error) {
+	bz, err := db.Get([]byte(pruneHeightsKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pruned heights: %w", err)
+	}
+	if len(bz) == 0 {
+		return nil, nil
+	}
+
+	return getPruningHeights(db)
+}
+
+// SetPruningHeights replaces the heights stored on disk to be pruned on the next run.
+func SetPruningHeights(db dbm.DB, pruneHeights []int64) error {
+	batch := db.NewBatch()
+	defer batch.Close()
+
+	setPruningHeights(batch, pruneHeights)
+
+	return batch.WriteSync()
+}
+
 func getPruningHeights(db dbm.DB) ([]int64, error) {
 	bz, err := db.Get([]byte(pruneHeightsKey))
 	if err != nil {
@@ -1130,15 +1253,21 @@ func getPruningHeights(db dbm.DB) ([]int64, error) {
 	return prunedHeights, nil
 }
 
-func flushMetadata(db dbm.DB, version int64, cInfo *types.CommitInfo, pruneHeights []int64) {
+func flushMetadata(db dbm.DB, version int64, cInfo *types.CommitInfo, pruneHeights []int64) error {
 	batch := db.NewBatch()
 	defer batch.Close()
 
-	setCommitInfo(batch, version, cInfo)
-	setLatestVersion(batch, version)
+	if err := setCommitInfo(batch, version, cInfo); err != nil {
+		return err
+	}
+	if err := setLatestVersion(batch, version); err != nil {
+		return err
+	}
 	setPruningHeights(batch, pruneHeights)
 
 	if err := batch.Write(); err != nil {
-		panic(fmt.Errorf("error on batch write %w", err))
+		return fmt.Errorf("error on batch write %w", err)
 	}
+
+	return nil
 }
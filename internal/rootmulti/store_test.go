@@ -0,0 +1,30 @@
+package rootmulti
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+
+	"github.com/cosmos/cosmos-sdk/store/types"
+)
+
+func TestPruneStore(t *testing.T) {
+	key := types.NewKVStoreKey("acc")
+	store := NewStore(dbm.NewMemDB())
+	store.MountStoreWithDB(key, types.StoreTypeIAVL, nil)
+	require.NoError(t, store.LoadLatestVersion())
+
+	for i := byte(0); i < 5; i++ {
+		store.GetKVStore(key).Set([]byte("key"), []byte{i})
+		store.Commit()
+	}
+
+	// a successful prune is a nil error, not a nil *PruneError
+	var err error = store.PruneStore(key, []int64{1, 2, 3}, 2)
+	require.NoError(t, err)
+	require.Equal(t, []int{4, 5}, store.GetAllVersions())
+
+	err = store.PruneStore(types.NewKVStoreKey("bank"), []int64{4}, 1)
+	require.NoError(t, err)
+}
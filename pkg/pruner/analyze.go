@@ -0,0 +1,198 @@
+package pruner
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/cosmos/cosmos-sdk/types"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	db "github.com/tendermint/tm-db"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/dbutil"
+	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+)
+
+// RecordStats counts the keys and the bytes of their keys and values
+type RecordStats struct {
+	Keys  int64 `json:"keys"`
+	Bytes int64 `json:"bytes"`
+}
+
+func (r *RecordStats) add(key, value []byte) {
+	r.Keys++
+	r.Bytes += int64(len(key) + len(value))
+}
+
+// StoreUsage splits the records under s/k:<store>/ into IAVL nodes, orphans and roots
+type StoreUsage struct {
+	Name    string      `json:"name"`
+	Disk    int64       `json:"disk_bytes"`
+	Nodes   RecordStats `json:"nodes"`
+	Orphans RecordStats `json:"orphans"`
+	Roots   RecordStats `json:"roots"`
+	Other   RecordStats `json:"other"`
+}
+
+// Total returns the records of all kinds together
+func (s StoreUsage) Total() RecordStats {
+	return RecordStats{
+		Keys:  s.Nodes.Keys + s.Orphans.Keys + s.Roots.Keys + s.Other.Keys,
+		Bytes: s.Nodes.Bytes + s.Orphans.Bytes + s.Roots.Bytes + s.Other.Bytes,
+	}
+}
+
+// PrefixUsage is the usage of a module level key prefix in the latest tree of a store
+type PrefixUsage struct {
+	Prefix string `json:"prefix"`
+	RecordStats
+}
+
+// StoreUsages scans every record of the application state and sums them per store and IAVL
+// record type, without modifying them. It stops once ctx is done and returns the stores scanned
+// so far.
+func (p *Pruner) StoreUsages(ctx context.Context) ([]StoreUsage, error) {
+	o := opt.Options{
+		DisableSeeksCompaction: true,
+		ReadOnly:               true,
+	}
+
+	appDB, err := db.NewGoLevelDBWithOpts("application", p.dbDir(), &o)
+	if err != nil {
+		return nil, err
+	}
+	defer appDB.Close()
+
+	itr, err := appDB.Iterator([]byte("s/k:"), []byte("s/k;"))
+	if err != nil {
+		return nil, err
+	}
+	defer itr.Close()
+
+	usages := make([]StoreUsage, 0)
+	var usage *StoreUsage
+	var prefix []byte
+	n := int64(0)
+	for ; itr.Valid(); itr.Next() {
+		key, value := itr.Key(), itr.Value()
+
+		// keys are ordered, so all records of a store are scanned in a row
+		if usage == nil || !bytes.HasPrefix(key, prefix) {
+			if err := ctx.Err(); err != nil {
+				return usages, err
+			}
+
+			end := bytes.IndexByte(key[4:], '/')
+			if end < 0 {
+				continue
+			}
+			usages = append(usages, StoreUsage{Name: string(key[4 : 4+end])})
+			usage = &usages[len(usages)-1]
+			prefix = append([]byte{}, key[:4+end+1]...)
+		}
+
+		n++
+		if n%1000000 == 0 {
+			p.progress(StageAnalyze, usage.Name, n, 0, "scanned %d keys, at store %s", n, usage.Name)
+		}
+
+		if len(key) == len(prefix) {
+			usage.Other.add(key, value)
+			continue
+		}
+
+		switch key[len(prefix)] {
+		case 'n':
+			usage.Nodes.add(key, value)
+		case 'o':
+			usage.Orphans.add(key, value)
+		case 'r':
+			usage.Roots.add(key, value)
+		default:
+			usage.Other.add(key, value)
+		}
+	}
+	if err := itr.Error(); err != nil {
+		return nil, err
+	}
+
+	for i := range usages {
+		prefix := []byte("s/k:" + usages[i].Name + "/")
+		size, err := dbutil.RangeSize(appDB, []dbutil.KeyRange{{Start: prefix, End: dbutil.PrefixEnd(prefix)}})
+		if err != nil {
+			return nil, err
+		}
+		usages[i].Disk = size
+	}
+
+	return usages, nil
+}
+
+// PrefixUsages sums the keys and values of the latest tree of a store per key prefix of
+// prefixLen bytes, and returns the latest version and the top largest prefixes. It stops once
+// ctx is done.
+func (p *Pruner) PrefixUsages(ctx context.Context, store string, top, prefixLen int) (int64, []PrefixUsage, error) {
+	if top < 0 || prefixLen < 1 {
+		return 0, nil, fmt.Errorf("invalid top %d or prefix length %d", top, prefixLen)
+	}
+
+	o := opt.Options{
+		DisableSeeksCompaction: true,
+		ReadOnly:               true,
+	}
+
+	appDB, err := db.NewGoLevelDBWithOpts("application", p.dbDir(), &o)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer appDB.Close()
+
+	key := types.NewKVStoreKey(store)
+
+	appStore := rootmulti.NewStore(appDB)
+	appStore.SetLazyLoading(true)
+	appStore.MountStoreWithDB(key, types.StoreTypeIAVL, nil)
+	if err := appStore.LoadLatestVersion(); err != nil {
+		return 0, nil, err
+	}
+
+	itr := appStore.GetKVStore(key).Iterator(nil, nil)
+	defer itr.Close()
+
+	byPrefix := make(map[string]*PrefixUsage)
+	for n := 0; itr.Valid(); itr.Next() {
+		if n++; n%100000 == 0 {
+			if err := ctx.Err(); err != nil {
+				return 0, nil, err
+			}
+		}
+
+		k := itr.Key()
+		prefix := k
+		if len(prefix) > prefixLen {
+			prefix = prefix[:prefixLen]
+		}
+
+		usage, ok := byPrefix[string(prefix)]
+		if !ok {
+			usage = &PrefixUsage{Prefix: hex.EncodeToString(prefix)}
+			byPrefix[string(prefix)] = usage
+		}
+		usage.add(k, itr.Value())
+	}
+
+	prefixes := make([]PrefixUsage, 0, len(byPrefix))
+	for _, usage := range byPrefix {
+		prefixes = append(prefixes, *usage)
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		return prefixes[i].Bytes > prefixes[j].Bytes
+	})
+	if len(prefixes) > top {
+		prefixes = prefixes[:top]
+	}
+
+	return appStore.LastCommitID().Version, prefixes, nil
+}
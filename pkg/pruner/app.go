@@ -0,0 +1,321 @@
+package pruner
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cosmos/cosmos-sdk/types"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	db "github.com/tendermint/tm-db"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/iavldb"
+	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+)
+
+// PruneError is the error of a store that failed to delete a batch of versions
+type PruneError = rootmulti.PruneError
+
+// StoresError is returned by PruneApp when some stores failed to be pruned, the others are pruned
+// regardless. The error of every store is in its StoreResult.
+type StoresError struct {
+	Failed int
+	Total  int
+}
+
+func (e *StoresError) Error() string {
+	return fmt.Sprintf("failed to prune %d of %d stores", e.Failed, e.Total)
+}
+
+// AppResult is the outcome of PruneApp
+type AppResult struct {
+	// Stores are sorted by name
+	Stores []StoreResult
+	// Compacted is whether the application db was compacted after pruning
+	Compacted bool
+}
+
+// StoreResult is the outcome of pruning a single store
+type StoreResult struct {
+	Name string
+	// Versions is the amount of versions of the store before pruning, ToPrune of them were to be
+	// pruned and Pruned of them were pruned, up to version Last
+	Versions int
+	ToPrune  int
+	Pruned   int
+	Last     int64
+	Batches  int
+	Duration time.Duration
+	// Heap is the heap in use when the store finished, shared with the stores pruned alongside
+	Heap uint64
+	// Stopped is whether the context was done before all versions were pruned
+	Stopped bool
+	Err     error
+}
+
+// Rate returns the versions pruned per second
+func (r StoreResult) Rate() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Pruned) / r.Duration.Seconds()
+}
+
+// PruneApp deletes the versions of the application state that are not retained, up to Parallel
+// stores at once, and compacts the application db afterwards unless ctx is done.
+func (p *Pruner) PruneApp(ctx context.Context) (*AppResult, error) {
+	dbDir := p.dbDir()
+
+	// the application db is compacted after pruning
+	if err := checkFreeSpace(1, rewrite{filepath.Join(dbDir, "application.db"), dbDir}); err != nil {
+		return nil, err
+	}
+
+	o := opt.Options{
+		DisableSeeksCompaction: true,
+	}
+
+	appDB, err := db.NewGoLevelDBWithOpts("application", dbDir, &o)
+	if err != nil {
+		return nil, err
+	}
+	defer appDB.Close()
+
+	if p.opts.Plan == nil {
+		latest, err := rootmulti.GetLatestVersion(appDB)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.checkPruneHeights(latest); err != nil {
+			return nil, err
+		}
+		if p.opts.ProtectUpgrades {
+			if _, err := p.loadUpgrades(appDB); err != nil {
+				return nil, err
+			}
+		}
+		if p.opts.ProtectSnapshots {
+			if _, err := p.Snapshots(ctx); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	p.progress(StageApp, "application", 0, 0, "pruning application state")
+
+	keys := p.storeKeys()
+
+	// the stores are loaded once and only up to their latest root, pruning only needs the orphans
+	appStore := rootmulti.NewStore(appDB)
+	appStore.SetIAVLCacheSize(p.opts.IAVLCacheSize)
+	appStore.SetLazyLoading(true)
+	for _, value := range keys {
+		appStore.MountStoreWithDB(value, types.StoreTypeIAVL, nil)
+	}
+	if err := appStore.LoadLatestVersion(); err != nil {
+		return nil, fmt.Errorf("failed to load application state: %w", err)
+	}
+
+	var mtx sync.Mutex
+	result := &AppResult{Stores: make([]StoreResult, 0, len(keys))}
+
+	queue := make(chan *types.KVStoreKey, len(keys))
+	for _, value := range keys {
+		queue <- value
+	}
+	close(queue)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < p.opts.Parallel && i < len(keys); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for value := range queue {
+				r := p.pruneStore(ctx, appDB, appStore, value)
+
+				mtx.Lock()
+				result.Stores = append(result.Stores, r)
+				mtx.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(result.Stores, func(i, j int) bool {
+		return result.Stores[i].Name < result.Stores[j].Name
+	})
+
+	failed := 0
+	for _, r := range result.Stores {
+		if r.Err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return result, &StoresError{Failed: failed, Total: len(result.Stores)}
+	}
+
+	if err := ctx.Err(); err != nil {
+		p.progress(StageApp, "application", 0, 0, "%s, skipping compaction of application state", StopReason(ctx))
+		return result, err
+	}
+
+	if !p.compacts("application") {
+		return result, nil
+	}
+
+	p.progress(StageApp, "application", 0, 0, "compacting application state")
+	if err := appDB.ForceCompact(nil, nil); err != nil {
+		return result, err
+	}
+	result.Compacted = true
+
+	return result, nil
+}
+
+// pruneStore deletes the versions of a single store of appStore that are not retained
+func (p *Pruner) pruneStore(ctx context.Context, appDB db.DB, appStore *rootmulti.Store, key *types.KVStoreKey) StoreResult {
+	result := StoreResult{Name: key.Name()}
+	start := time.Now()
+
+	// a lazily loaded store only knows its latest version, the others are read from the root records
+	storeDB := db.NewPrefixDB(appDB, []byte("s/k:"+key.Name()+"/"))
+	versions, _, err := iavldb.Roots(storeDB)
+	if err != nil {
+		result.Err = fmt.Errorf("failed to read versions of store %s: %w", key.Name(), err)
+		return result
+	}
+	heights := p.storeHeights(key.Name(), versions)
+	result.Versions = len(versions)
+	result.ToPrune = len(heights)
+
+	p.progress(StageApp, key.Name(), 0, int64(len(heights)), "pruning store: %+v (%d/%d)", key.Name(), len(heights), len(versions))
+	sizer := p.newBatchSizer()
+	var pruned int
+	if p.opts.Engine == EngineOrphanSweep {
+		pruned, err = p.pruneStoreRanges(ctx, storeDB, key.Name(), versions, heights, sizer)
+	} else {
+		pruned, err = p.pruneStoreBatches(ctx, appStore, storeDB, key, heights, sizer)
+	}
+	result.Batches = sizer.batches
+	result.Pruned = pruned
+	if pruned > 0 {
+		result.Last = heights[pruned-1]
+	}
+	if err != nil && err == ctx.Err() {
+		result.Stopped = true
+		p.progress(StageApp, key.Name(), int64(pruned), int64(len(heights)), "%s pruning store: %s", StopReason(ctx), key.Name())
+		return result
+	}
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.Duration = time.Since(start)
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	result.Heap = mem.HeapInuse
+
+	p.progress(StageApp, key.Name(), int64(pruned), int64(len(heights)), "finished pruning store: %s", key.Name())
+
+	return result
+}
+
+// pruneStoreBatches deletes the heights of a store through its iavl tree, oldest first in batches
+// sized by sizer. It returns the amount of heights deleted, and the error of ctx once it is done.
+func (p *Pruner) pruneStoreBatches(
+	ctx context.Context, appStore *rootmulti.Store, storeDB db.DB, key *types.KVStoreKey, heights []int64, sizer *batchSizer,
+) (int, error) {
+	pruned := 0
+	for len(heights) > 0 {
+		if err := ctx.Err(); err != nil {
+			return pruned, err
+		}
+
+		n := sizer.next()
+		if n > len(heights) {
+			n = len(heights)
+		}
+
+		var size int64
+		if sizer.adaptive() {
+			var err error
+			if size, err = iavldb.DeleteSize(storeDB, heights[0], heights[n-1]+1); err != nil {
+				return pruned, &PruneError{Store: key.Name(), From: heights[0], To: heights[n-1], Err: err}
+			}
+		}
+
+		start := time.Now()
+		if err := appStore.PruneStore(key, heights[:n], n); err != nil {
+			return pruned, err
+		}
+		sizer.observe(n, size, time.Since(start))
+
+		heights = heights[n:]
+		pruned += n
+	}
+
+	return pruned, nil
+}
+
+// pruneStoreRanges deletes the heights of a store straight from its orphan and root records
+// without loading its tree. Every range of heights is deleted in batches sized by sizer, which
+// are split in parts swept by up to StoreWorkers goroutines and written at once. Like
+// pruneStoreBatches, the oldest heights are deleted first and it stops between batches once ctx
+// is done.
+func (p *Pruner) pruneStoreRanges(
+	ctx context.Context, storeDB db.DB, name string, versions, heights []int64, sizer *batchSizer,
+) (int, error) {
+	// like iavl, the latest version is never deleted
+	if len(heights) > 0 && len(versions) > 0 && heights[len(heights)-1] == versions[len(versions)-1] {
+		heights = heights[:len(heights)-1]
+	}
+
+	workers := int64(p.opts.StoreWorkers)
+	for _, r := range iavldb.DeleteRanges(versions, heights) {
+		// the orphans of a range are deleted or moved to its predecessor one by one, so every
+		// batch of the range leaves the versions above it intact
+		for from := r.From; from < r.To; {
+			if err := ctx.Err(); err != nil {
+				return prunedBelow(heights, from), err
+			}
+
+			to := from + int64(sizer.next())
+			if to > r.To || to < from {
+				to = r.To
+			}
+			part := iavldb.Range{From: from, To: to, Predecessor: r.Predecessor}
+
+			var size int64
+			if sizer.adaptive() {
+				var err error
+				if size, err = iavldb.DeleteSize(storeDB, from, to); err != nil {
+					return prunedBelow(heights, from), &PruneError{Store: name, From: from, To: to - 1, Err: err}
+				}
+			}
+
+			start := time.Now()
+			partSize := (to - from + workers - 1) / workers
+			// a started batch is not stopped by ctx, it is written once all of its parts are swept
+			if _, err := iavldb.DeleteRange(context.Background(), storeDB, part, partSize, int(workers)); err != nil {
+				return prunedBelow(heights, from), &PruneError{Store: name, From: from, To: to - 1, Err: err}
+			}
+			sizer.observe(int(to-from), size, time.Since(start))
+
+			from = to
+		}
+	}
+
+	return len(heights), nil
+}
+
+// prunedBelow returns the amount of the sorted heights below height
+func prunedBelow(heights []int64, height int64) int {
+	return sort.Search(len(heights), func(i int) bool { return heights[i] >= height })
+}
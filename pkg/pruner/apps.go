@@ -0,0 +1,190 @@
+package pruner
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	authtypes "github.com/cosmos/cosmos-sdk/x/auth/types"
+	banktypes "github.com/cosmos/cosmos-sdk/x/bank/types"
+	capabilitytypes "github.com/cosmos/cosmos-sdk/x/capability/types"
+	distrtypes "github.com/cosmos/cosmos-sdk/x/distribution/types"
+	evidencetypes "github.com/cosmos/cosmos-sdk/x/evidence/types"
+	govtypes "github.com/cosmos/cosmos-sdk/x/gov/types"
+	minttypes "github.com/cosmos/cosmos-sdk/x/mint/types"
+	paramstypes "github.com/cosmos/cosmos-sdk/x/params/types"
+	slashingtypes "github.com/cosmos/cosmos-sdk/x/slashing/types"
+	stakingtypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+	upgradetypes "github.com/cosmos/cosmos-sdk/x/upgrade/types"
+	ibctransfertypes "github.com/cosmos/ibc-go/v2/modules/apps/transfer/types"
+	ibchost "github.com/cosmos/ibc-go/v2/modules/core/24-host"
+	"github.com/spf13/viper"
+)
+
+// AppProfile describes the application state of a chain
+type AppProfile interface {
+	// Name selects the profile, e.g. with --app
+	Name() string
+	// StoreKeys are the names of the IAVL stores of the application state to be pruned
+	StoreKeys() []string
+	// Retention is the recommended retention of the application state and the blocks
+	Retention() Retention
+	// ProtectedHeights are the versions of the application state that are never pruned
+	ProtectedHeights() []int64
+}
+
+// Retention is how much of the history of a node is kept
+type Retention struct {
+	KeepRecent uint64
+	KeepEvery  uint64
+	KeepBlocks uint64
+}
+
+// NewAppProfile returns an AppProfile of the given values
+func NewAppProfile(name string, storeKeys []string, retention Retention, protectedHeights []int64) AppProfile {
+	return &appProfile{
+		name:      name,
+		storeKeys: storeKeys,
+		retention: retention,
+		protected: protectedHeights,
+	}
+}
+
+type appProfile struct {
+	name      string
+	storeKeys []string
+	retention Retention
+	protected []int64
+}
+
+func (a *appProfile) Name() string              { return a.name }
+func (a *appProfile) StoreKeys() []string       { return a.storeKeys }
+func (a *appProfile) Retention() Retention      { return a.retention }
+func (a *appProfile) ProtectedHeights() []int64 { return a.protected }
+
+// SDKStoreKeys are the stores of the cosmos-sdk and ibc modules most chains have
+var SDKStoreKeys = []string{
+	authtypes.StoreKey, banktypes.StoreKey, stakingtypes.StoreKey,
+	minttypes.StoreKey, distrtypes.StoreKey, slashingtypes.StoreKey,
+	govtypes.StoreKey, paramstypes.StoreKey, ibchost.StoreKey, upgradetypes.StoreKey,
+	evidencetypes.StoreKey, ibctransfertypes.StoreKey, capabilitytypes.StoreKey,
+}
+
+// DefaultApp is the app profile of a Pruner without Options.App
+const DefaultApp = "cosmos-sdk"
+
+var (
+	appsMtx sync.RWMutex
+	apps    = map[string]AppProfile{}
+)
+
+func init() {
+	sdkRetention := Retention{KeepRecent: 362880}
+
+	for _, a := range []AppProfile{
+		NewAppProfile(DefaultApp, SDKStoreKeys, sdkRetention, nil),
+		NewAppProfile("bandchain", withSDKStoreKeys("feegrant", "authz", "oracle", "icahost"),
+			Retention{KeepRecent: 400000, KeepEvery: 100}, nil),
+		NewAppProfile("gaia", withSDKStoreKeys("feegrant", "authz", "liquidity", "icahost"), sdkRetention, nil),
+		NewAppProfile("wasmd", withSDKStoreKeys("feegrant", "authz", "wasm"), sdkRetention, nil),
+	} {
+		RegisterAppProfile(a)
+	}
+}
+
+// withSDKStoreKeys returns SDKStoreKeys followed by the keys of an app
+func withSDKStoreKeys(keys ...string) []string {
+	return append(append([]string{}, SDKStoreKeys...), keys...)
+}
+
+// RegisterAppProfile makes an app profile available by its name, replacing any profile of the
+// same name
+func RegisterAppProfile(a AppProfile) {
+	appsMtx.Lock()
+	defer appsMtx.Unlock()
+
+	apps[a.Name()] = a
+}
+
+// LookupAppProfile returns the registered app profile of the given name
+func LookupAppProfile(name string) (AppProfile, error) {
+	appsMtx.RLock()
+	defer appsMtx.RUnlock()
+
+	a, ok := apps[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown app %q", name)
+	}
+
+	return a, nil
+}
+
+// AppProfiles returns the registered app profiles sorted by name
+func AppProfiles() []AppProfile {
+	appsMtx.RLock()
+	defer appsMtx.RUnlock()
+
+	list := make([]AppProfile, 0, len(apps))
+	for _, a := range apps {
+		list = append(list, a)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name() < list[j].Name()
+	})
+
+	return list
+}
+
+// appDefinition is an app profile in a profiles file. Base adds the stores of another profile,
+// which must be registered or defined earlier in the file.
+type appDefinition struct {
+	Name             string   `mapstructure:"name"`
+	Base             string   `mapstructure:"base"`
+	Stores           []string `mapstructure:"stores"`
+	KeepRecent       uint64   `mapstructure:"pruning-keep-recent"`
+	KeepEvery        uint64   `mapstructure:"pruning-keep-every"`
+	KeepBlocks       uint64   `mapstructure:"min-retain-blocks"`
+	ProtectedHeights []int64  `mapstructure:"protected-heights"`
+}
+
+// RegisterAppProfilesFile registers the app profiles of the [[apps]] tables of a TOML file, or the
+// apps list of a YAML file, and returns them
+func RegisterAppProfilesFile(path string) ([]AppProfile, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read app profiles: %w", err)
+	}
+
+	var defs []appDefinition
+	if err := v.UnmarshalKey("apps", &defs); err != nil {
+		return nil, fmt.Errorf("failed to read app profiles from %s: %w", path, err)
+	}
+
+	loaded := make([]AppProfile, 0, len(defs))
+	for _, def := range defs {
+		if def.Name == "" {
+			return nil, fmt.Errorf("app profile without name in %s", path)
+		}
+
+		stores := []string{}
+		if def.Base != "" {
+			base, err := LookupAppProfile(def.Base)
+			if err != nil {
+				return nil, fmt.Errorf("app profile %s in %s: %w", def.Name, path, err)
+			}
+			stores = append(stores, base.StoreKeys()...)
+		}
+		stores = append(stores, def.Stores...)
+
+		a := NewAppProfile(def.Name, stores, Retention{
+			KeepRecent: def.KeepRecent,
+			KeepEvery:  def.KeepEvery,
+			KeepBlocks: def.KeepBlocks,
+		}, def.ProtectedHeights)
+		RegisterAppProfile(a)
+		loaded = append(loaded, a)
+	}
+
+	return loaded, nil
+}
@@ -0,0 +1,71 @@
+package pruner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterAppProfilesFile(t *testing.T) {
+	for name, content := range map[string]string{
+		"apps.toml": `
+[[apps]]
+name = "toml-chain"
+base = "cosmos-sdk"
+stores = ["wasm"]
+pruning-keep-recent = 100
+min-retain-blocks = 200000
+protected-heights = [10, 20]
+`,
+		"apps.yaml": `
+apps:
+  - name: yaml-chain
+    stores: [acc, bank]
+    pruning-keep-every: 1000
+`,
+	} {
+		path := filepath.Join(t.TempDir(), name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+		loaded, err := RegisterAppProfilesFile(path)
+		require.NoError(t, err)
+		require.Len(t, loaded, 1)
+	}
+
+	a, err := LookupAppProfile("toml-chain")
+	require.NoError(t, err)
+	require.Equal(t, append(append([]string{}, SDKStoreKeys...), "wasm"), a.StoreKeys())
+	require.Equal(t, Retention{KeepRecent: 100, KeepBlocks: 200000}, a.Retention())
+	require.Equal(t, []int64{10, 20}, a.ProtectedHeights())
+
+	a, err = LookupAppProfile("yaml-chain")
+	require.NoError(t, err)
+	require.Equal(t, []string{"acc", "bank"}, a.StoreKeys())
+	require.Equal(t, Retention{KeepEvery: 1000}, a.Retention())
+
+	_, err = LookupAppProfile("unknown")
+	require.Error(t, err)
+}
+
+func TestRegisterAppProfilesFileUnknownBase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apps.toml")
+	require.NoError(t, os.WriteFile(path, []byte("[[apps]]\nname = \"chain\"\nbase = \"missing\"\n"), 0o600))
+
+	_, err := RegisterAppProfilesFile(path)
+	require.Error(t, err)
+}
+
+func TestPruningProfiles(t *testing.T) {
+	p, err := LookupPruningProfile("validator")
+	require.NoError(t, err)
+	require.Equal(t, Retention{KeepRecent: 100, KeepBlocks: 100000}, p.Retention)
+
+	_, err = LookupPruningProfile("custom")
+	require.Error(t, err)
+
+	list := PruningProfiles()
+	require.Len(t, list, 10)
+	require.Equal(t, "default", list[0].Name)
+}
@@ -0,0 +1,83 @@
+package pruner
+
+import (
+	"math"
+	"runtime"
+	"time"
+)
+
+// initialBatchVersions is the amount of versions of the first adaptive batch, before the size of
+// a version is known
+const initialBatchVersions = 10
+
+// batchSizer sizes the batches of versions deleted from a store. With a target size in bytes the
+// versions of the next batch follow from the bytes written per version by the batch before, and
+// shrink when its write latency or the heap in use get too high. Otherwise every batch has the
+// fixed amount of versions of Options.Batch.
+type batchSizer struct {
+	versions int
+	target   int64
+	// maxLatency and maxHeap are not checked when 0
+	maxLatency time.Duration
+	maxHeap    uint64
+
+	batches int
+}
+
+// newBatchSizer returns the sizer of the batches of a store for the batch options
+func (p *Pruner) newBatchSizer() *batchSizer {
+	if p.opts.BatchBytes <= 0 {
+		versions := int(p.opts.Batch)
+		if versions <= 0 {
+			// a single batch
+			versions = math.MaxInt32
+		}
+		return &batchSizer{versions: versions}
+	}
+
+	return &batchSizer{
+		versions:   initialBatchVersions,
+		target:     p.opts.BatchBytes,
+		maxLatency: p.opts.BatchLatency,
+		maxHeap:    p.opts.BatchMemory,
+	}
+}
+
+// adaptive returns whether the batches are sized by bytes
+func (b *batchSizer) adaptive() bool {
+	return b.target > 0
+}
+
+// next returns the amount of versions of the next batch
+func (b *batchSizer) next() int {
+	return b.versions
+}
+
+// observe sizes the next batch from a batch of versions that wrote size bytes in latency
+func (b *batchSizer) observe(versions int, size int64, latency time.Duration) {
+	b.batches++
+	if !b.adaptive() {
+		return
+	}
+
+	next := 2 * versions
+	if size > 0 {
+		// grow at most twice as large at once, a few quiet versions say little about the next ones
+		next = int(math.Min(float64(next), float64(versions)*float64(b.target)/float64(size)))
+	}
+	if b.maxLatency > 0 && latency > b.maxLatency {
+		next = int(math.Min(float64(next), float64(versions)/2))
+	}
+	if b.maxHeap > 0 {
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		if mem.HeapInuse > b.maxHeap {
+			next = int(math.Min(float64(next), float64(versions)/2))
+		}
+	}
+	if next < 1 {
+		next = 1
+	}
+
+	b.versions = next
+}
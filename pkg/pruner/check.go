@@ -0,0 +1,178 @@
+package pruner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/neilotoole/errgroup"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	db "github.com/tendermint/tm-db"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/iavldb"
+	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+)
+
+// Problem is an inconsistency found in the IAVL records of a store
+type Problem = iavldb.Problem
+
+// ProblemCommitInfo is the kind of problem of a commit info that can not be read
+const ProblemCommitInfo = "unreadable commit info"
+
+// StoreCheck is the result of checking the trees and orphans of a store
+type StoreCheck struct {
+	Name string
+	// Versions is the amount of versions of the store, Checked the amount of them walked
+	Versions int
+	Checked  int
+	Nodes    int64
+	Problems []Problem
+}
+
+// Check walks the trees of sample versions of the stores, or of all versions if sample is 0,
+// compares their root hashes to the commit info and checks the orphan records against the
+// existing versions, without modifying them. No stores check every store of the application db.
+// Up to Parallel stores are checked at once. It stops between stores once ctx is done and returns
+// the stores checked so far, sorted by name.
+func (p *Pruner) Check(ctx context.Context, stores []string, sample int) ([]StoreCheck, error) {
+	o := opt.Options{
+		DisableSeeksCompaction: true,
+		ReadOnly:               true,
+	}
+
+	appDB, err := db.NewGoLevelDBWithOpts("application", p.dbDir(), &o)
+	if err != nil {
+		return nil, err
+	}
+	defer appDB.Close()
+
+	if len(stores) == 0 {
+		if stores, err = rootmulti.GetStoreNames(appDB); err != nil {
+			return nil, err
+		}
+	}
+
+	var mtx sync.Mutex
+	checks := make([]StoreCheck, 0, len(stores))
+
+	// every store is queued at once, workers are only started for a queue that is not empty
+	errs, _ := errgroup.WithContextN(ctx, p.opts.Parallel, len(stores))
+	for _, name := range stores {
+		name := name
+		errs.Go(func() error {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			check, err := p.checkStore(appDB, name, sample)
+			if err != nil {
+				return fmt.Errorf("failed to check store %s: %w", name, err)
+			}
+
+			mtx.Lock()
+			checks = append(checks, check)
+			mtx.Unlock()
+
+			return nil
+		})
+	}
+	err = errs.Wait()
+
+	sort.Slice(checks, func(i, j int) bool {
+		return checks[i].Name < checks[j].Name
+	})
+	if err != nil {
+		return checks, err
+	}
+
+	return checks, ctx.Err()
+}
+
+// checkStore walks the tree of the sampled versions of a store, compares their root hashes to
+// the commit info and checks the orphan records against the existing versions
+func (p *Pruner) checkStore(appDB db.DB, name string, sample int) (StoreCheck, error) {
+	storeDB := db.NewPrefixDB(appDB, []byte("s/k:"+name+"/"))
+
+	versions, roots, err := iavldb.Roots(storeDB)
+	if err != nil {
+		return StoreCheck{}, err
+	}
+
+	check := StoreCheck{Name: name, Versions: len(versions), Problems: make([]Problem, 0)}
+	checker := iavldb.NewChecker(storeDB)
+
+	p.progress(StageCheck, name, 0, int64(len(versions)), "checking store: %s (%d versions)", name, len(versions))
+	for _, version := range sampleVersions(versions, sample) {
+		problems, err := checker.CheckTree(version, roots[version])
+		if err != nil {
+			return check, err
+		}
+		check.Problems = append(check.Problems, problems...)
+		check.Checked++
+
+		if problem := checkCommitInfo(appDB, name, version, roots[version]); problem != nil {
+			check.Problems = append(check.Problems, *problem)
+		}
+	}
+	check.Nodes = checker.Nodes
+
+	problems, err := iavldb.CheckOrphans(storeDB, versions)
+	if err != nil {
+		return check, err
+	}
+	check.Problems = append(check.Problems, problems...)
+
+	return check, nil
+}
+
+// checkCommitInfo compares the root hash of a version with the hash in the commit info of the
+// multistore, if there is any for that version. A commit info that can not be read is a problem.
+func checkCommitInfo(appDB db.DB, name string, version int64, rootHash []byte) *Problem {
+	if len(rootHash) == 0 {
+		return nil
+	}
+
+	cInfo, err := rootmulti.GetCommitInfo(appDB, version)
+	if errors.Is(err, rootmulti.ErrCommitInfoNotFound) {
+		return nil
+	}
+	if err != nil {
+		return &Problem{
+			Version: version,
+			Kind:    ProblemCommitInfo,
+			Detail:  err.Error(),
+			Key:     []byte(fmt.Sprintf("s/%d", version)),
+		}
+	}
+
+	for _, info := range cInfo.StoreInfos {
+		if info.Name == name && !bytes.Equal(info.CommitId.Hash, rootHash) {
+			return &Problem{
+				Version: version,
+				Kind:    iavldb.ProblemHashMismatch,
+				Detail:  fmt.Sprintf("root %X differs from commit info %X", rootHash, info.CommitId.Hash),
+			}
+		}
+	}
+
+	return nil
+}
+
+// sampleVersions returns n versions spread evenly over the sorted versions, always including the
+// latest one. n = 0 returns all versions.
+func sampleVersions(versions []int64, n int) []int64 {
+	if n <= 0 || n >= len(versions) {
+		return versions
+	}
+
+	sampled := make([]int64, 0, n)
+	step := float64(len(versions)-1) / float64(n-1)
+	for i := 0; i < n-1; i++ {
+		sampled = append(sampled, versions[int(float64(i)*step)])
+	}
+
+	return append(sampled, versions[len(versions)-1])
+}
@@ -0,0 +1,83 @@
+package pruner
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	db "github.com/tendermint/tm-db"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/iavldb"
+)
+
+func TestCheckAndRepair(t *testing.T) {
+	home := t.TempDir()
+	dataDir := filepath.Join(home, "data")
+	saveAppSnapshot(t, dataDir, home, 5, 5, "acc")
+
+	p, err := New(Options{Home: home, Parallel: 2})
+	require.NoError(t, err)
+
+	checks, err := p.Check(context.Background(), nil, 0)
+	require.NoError(t, err)
+	// the memory store is in the commit info without any versions
+	require.Len(t, checks, 3)
+	for _, check := range checks[:2] {
+		require.Equal(t, 5, check.Versions)
+		require.Equal(t, 5, check.Checked)
+		require.Empty(t, check.Problems)
+	}
+	require.Equal(t, "memory", checks[2].Name)
+
+	// the root of version 2 of acc is only part of that tree, and the commit info of version 4
+	// can not be read
+	appDB, err := db.NewGoLevelDB("application", dataDir)
+	require.NoError(t, err)
+	root, err := appDB.Get(append([]byte("s/k:acc/"), iavldb.RootKey(2)...))
+	require.NoError(t, err)
+	require.NoError(t, appDB.Delete(append([]byte("s/k:acc/"), iavldb.NodeKey(root)...)))
+	require.NoError(t, appDB.Set([]byte(fmt.Sprintf("s/%d", 4)), []byte{0xff, 0xff}))
+	require.NoError(t, appDB.Close())
+
+	checks, err = p.Check(context.Background(), []string{"acc"}, 0)
+	require.NoError(t, err)
+	require.Len(t, checks, 1)
+	kinds := make(map[int64][]string)
+	for _, problem := range checks[0].Problems {
+		kinds[problem.Version] = append(kinds[problem.Version], problem.Kind)
+	}
+	require.NotEmpty(t, kinds[2])
+	require.Equal(t, []string{ProblemCommitInfo}, kinds[4])
+
+	// a dry run does not remove the broken version
+	result, err := p.Repair(context.Background(), true)
+	require.NoError(t, err)
+	require.Equal(t, 3, result.StoreCount)
+	require.Equal(t, []int64{2}, result.Stores[0].Broken)
+	checks, err = p.Check(context.Background(), []string{"acc"}, 0)
+	require.NoError(t, err)
+	require.Equal(t, 5, checks[0].Versions)
+
+	result, err = p.Repair(context.Background(), false)
+	require.NoError(t, err)
+	require.Equal(t, "acc", result.Stores[0].Name)
+	require.Equal(t, []int64{2}, result.Stores[0].Broken)
+	require.Equal(t, []int64{1, 3, 4, 5}, result.Stores[0].Versions)
+	require.Empty(t, result.Stores[1].Broken)
+
+	checks, err = p.Check(context.Background(), []string{"acc"}, 0)
+	require.NoError(t, err)
+	require.Equal(t, 4, checks[0].Versions)
+	for _, problem := range checks[0].Problems {
+		require.Equal(t, ProblemCommitInfo, problem.Kind)
+	}
+
+	// nothing is checked once ctx is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	checks, err = p.Check(ctx, nil, 0)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Empty(t, checks)
+}
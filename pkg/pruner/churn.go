@@ -0,0 +1,117 @@
+package pruner
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sort"
+
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	db "github.com/tendermint/tm-db"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+)
+
+// ChurnPoint is the amount of nodes orphaned by the versions of a bucket starting at Version
+type ChurnPoint struct {
+	Version int64 `json:"version"`
+	RecordStats
+}
+
+// StoreChurn is the time series of the nodes orphaned in a store
+type StoreChurn struct {
+	Name   string       `json:"name"`
+	Total  RecordStats  `json:"total"`
+	Series []ChurnPoint `json:"series"`
+}
+
+// Churn sums the nodes orphaned by the versions from up to to (0=latest) of the stores, all
+// stores of the application state if none are given, in buckets of interval versions, without
+// modifying them. The stores are sorted by the bytes orphaned, largest first. It stops between
+// stores once ctx is done.
+func (p *Pruner) Churn(ctx context.Context, stores []string, from, to, interval int64) ([]StoreChurn, error) {
+	if interval < 1 {
+		return nil, errors.New("interval must be at least 1")
+	}
+	if from < 1 {
+		from = 1
+	}
+
+	o := opt.Options{
+		DisableSeeksCompaction: true,
+		ReadOnly:               true,
+	}
+
+	appDB, err := db.NewGoLevelDBWithOpts("application", p.dbDir(), &o)
+	if err != nil {
+		return nil, err
+	}
+	defer appDB.Close()
+
+	if len(stores) == 0 {
+		if stores, err = rootmulti.GetStoreNames(appDB); err != nil {
+			return nil, err
+		}
+	}
+
+	churns := make([]StoreChurn, 0, len(stores))
+	for _, name := range stores {
+		if err := ctx.Err(); err != nil {
+			return churns, err
+		}
+
+		p.progress(StageChurn, name, 0, 0, "walking orphans of store: %s", name)
+		churn, err := orphanChurn(appDB, name, from, to, interval)
+		if err != nil {
+			return nil, err
+		}
+		churns = append(churns, churn)
+	}
+
+	sort.Slice(churns, func(i, j int) bool {
+		return churns[i].Total.Bytes > churns[j].Total.Bytes
+	})
+
+	return churns, nil
+}
+
+// orphanChurn walks the orphan records o<toVersion><fromVersion><hash> of a store. A node is
+// orphaned by the first version saved after toVersion, which is toVersion+1 unless that version
+// was pruned, in which case the orphan was moved to the kept version before it.
+func orphanChurn(appDB db.DB, name string, from, to, interval int64) (StoreChurn, error) {
+	prefix := []byte("s/k:" + name + "/")
+
+	end := to
+	if end <= 0 {
+		end = int64(^uint64(0) >> 1)
+	}
+	r := versionRange(prefix, 'o', from-1, end)
+
+	itr, err := appDB.Iterator(r.Start, r.End)
+	if err != nil {
+		return StoreChurn{}, err
+	}
+	defer itr.Close()
+
+	churn := StoreChurn{Name: name, Series: make([]ChurnPoint, 0)}
+	for ; itr.Valid(); itr.Next() {
+		key, hash := itr.Key(), itr.Value()
+
+		version := int64(binary.BigEndian.Uint64(key[len(prefix)+1:len(prefix)+9])) + 1
+		bucket := version - version%interval
+
+		node, err := appDB.Get(append(append(append([]byte{}, prefix...), 'n'), hash...))
+		if err != nil {
+			return churn, err
+		}
+
+		// orphans are ordered by toVersion, so the buckets are appended in order
+		if n := len(churn.Series); n == 0 || churn.Series[n-1].Version != bucket {
+			churn.Series = append(churn.Series, ChurnPoint{Version: bucket})
+		}
+		churn.Series[len(churn.Series)-1].add(hash, node)
+		churn.Total.add(hash, node)
+	}
+
+	return churn, itr.Error()
+}
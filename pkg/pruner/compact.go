@@ -0,0 +1,366 @@
+package pruner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/neilotoole/errgroup"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	db "github.com/tendermint/tm-db"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/dbutil"
+	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+)
+
+// CompactResult is the outcome of compacting a single db
+type CompactResult struct {
+	Name string
+	Path string
+	// Missing is whether the db does not exist, Compacted whether it was compacted before the
+	// context was done
+	Missing   bool
+	Compacted bool
+	// Before and After are the sizes of the db on disk
+	Before int64
+	After  int64
+}
+
+// compactTarget is a database under the data directory to be compacted range by range
+type compactTarget struct {
+	name string
+	dir  string
+	// ranges returns the key ranges to compact, a nil result compacts the whole keyspace
+	ranges func(db.DB) ([]dbutil.KeyRange, error)
+}
+
+// Compact compacts the dbs of the application state if CosmosSDK is set and those of tendermint
+// if Tendermint is set, up to Parallel dbs at once. It refuses to start with a *SpaceError if the
+// largest Parallel dbs compacted into a filesystem might not fit on it together. The results are
+// in the order of the dbs.
+func (p *Pruner) Compact(ctx context.Context) ([]CompactResult, error) {
+	targets := p.compactTargets()
+
+	if p.opts.OutDir != "" {
+		if err := os.MkdirAll(p.opts.OutDir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	// refuse before touching any db rather than failing halfway through
+	rewrites := make([]rewrite, 0, len(targets))
+	for _, target := range targets {
+		dir := target.dir
+		if p.opts.OutDir != "" {
+			dir = p.opts.OutDir
+		}
+		rewrites = append(rewrites, rewrite{filepath.Join(target.dir, target.name+".db"), dir})
+	}
+	if err := checkFreeSpace(p.opts.Parallel, rewrites...); err != nil {
+		return nil, err
+	}
+
+	results := make([]CompactResult, len(targets))
+	// every db is queued at once, workers are only started for a queue that is not empty
+	errs, _ := errgroup.WithContextN(ctx, p.opts.Parallel, len(targets))
+	for i, target := range targets {
+		i, target := i, target
+		errs.Go(func() error {
+			var err error
+			results[i], err = p.compactDB(ctx, target)
+			return err
+		})
+	}
+
+	if err := errs.Wait(); err != nil {
+		return results, err
+	}
+
+	return results, ctx.Err()
+}
+
+// compactTargets returns the dbs of the application state if CosmosSDK is set and those of
+// tendermint if Tendermint is set
+func (p *Pruner) compactTargets() []compactTarget {
+	dbDir := p.dbDir()
+
+	targets := []compactTarget{}
+	if p.opts.CosmosSDK {
+		targets = append(targets,
+			compactTarget{"application", dbDir, appStoreRanges},
+			compactTarget{"metadata", filepath.Join(dbDir, "snapshots"), nil},
+		)
+	}
+	if p.opts.Tendermint {
+		targets = append(targets,
+			compactTarget{"blockstore", dbDir, blockStoreRanges},
+			compactTarget{"state", dbDir, nil},
+			compactTarget{"tx_index", dbDir, nil},
+			compactTarget{"evidence", dbDir, nil},
+		)
+	}
+
+	return targets
+}
+
+// compactDB compacts every key range of the target and measures its size on disk before and after
+func (p *Pruner) compactDB(ctx context.Context, target compactTarget) (CompactResult, error) {
+	path := filepath.Join(target.dir, target.name+".db")
+	result := CompactResult{Name: target.name, Path: path}
+	if ctx.Err() != nil {
+		p.progress(StageCompact, target.name, 0, 0, "skipping %s: %s", target.name, StopReason(ctx))
+		return result, nil
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		p.progress(StageCompact, target.name, 0, 0, "skipping %s: %s does not exist", target.name, path)
+		result.Missing = true
+		return result, nil
+	}
+
+	var err error
+	if result.Before, err = dbutil.DirSize(path); err != nil {
+		return result, err
+	}
+
+	o := opt.Options{
+		DisableSeeksCompaction: true,
+	}
+
+	tdb, err := db.NewGoLevelDBWithOpts(target.name, target.dir, &o)
+	if err != nil {
+		return result, err
+	}
+
+	if p.opts.OutDir != "" {
+		err = p.rewriteDB(ctx, target, tdb)
+	} else {
+		err = p.compactRanges(ctx, target, tdb)
+	}
+	if err != nil {
+		tdb.Close()
+		return result, err
+	}
+
+	// obsolete tables are only released once the db is closed
+	if err := tdb.Close(); err != nil {
+		return result, err
+	}
+
+	if ctx.Err() != nil {
+		p.progress(StageCompact, target.name, 0, 0, "stopped compacting %s: %s", target.name, StopReason(ctx))
+		if p.opts.OutDir != "" {
+			// the db was not replaced, only its partial copy is removed
+			return result, os.RemoveAll(filepath.Join(p.opts.OutDir, target.name+".db"))
+		}
+		return result, nil
+	}
+
+	if p.opts.OutDir != "" {
+		p.progress(StageCompact, target.name, 0, 0, "moving %s into place", target.name)
+		if err := replaceDB(filepath.Join(p.opts.OutDir, target.name+".db"), path); err != nil {
+			return result, err
+		}
+	}
+
+	if result.After, err = dbutil.DirSize(path); err != nil {
+		return result, err
+	}
+	result.Compacted = true
+
+	p.progress(StageCompact, target.name, 0, 0, "compacted %s: %s -> %s",
+		target.name, dbutil.FormatBytes(result.Before), dbutil.FormatBytes(result.After))
+
+	return result, nil
+}
+
+// compactRanges compacts the key ranges of the target one at a time
+func (p *Pruner) compactRanges(ctx context.Context, target compactTarget, tdb db.DB) error {
+	ranges := []dbutil.KeyRange{{Name: "all"}}
+	if target.ranges != nil {
+		var err error
+		if ranges, err = target.ranges(tdb); err != nil {
+			return err
+		}
+	}
+
+	for i, r := range ranges {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		p.progress(StageCompact, target.name, int64(i), int64(len(ranges)),
+			"compacting %s [%d/%d]: %s", target.name, i+1, len(ranges), r.Name)
+		if err := tdb.ForceCompact(r.Start, r.End); err != nil {
+			return fmt.Errorf("failed to compact %s range %s: %w", target.name, r.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// rewriteDB copies every key of the target into a new db under OutDir, which leaves the copy
+// without any deleted or overwritten entries. It stops between batches once ctx is done.
+func (p *Pruner) rewriteDB(ctx context.Context, target compactTarget, tdb db.DB) error {
+	outDir := p.opts.OutDir
+	if _, err := os.Stat(filepath.Join(outDir, target.name+".db")); err == nil {
+		return fmt.Errorf("%s already exists in %s", target.name+".db", outDir)
+	}
+
+	o := opt.Options{
+		DisableSeeksCompaction: true,
+	}
+
+	out, err := db.NewGoLevelDBWithOpts(target.name, outDir, &o)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	itr, err := tdb.Iterator(nil, nil)
+	if err != nil {
+		return err
+	}
+	defer itr.Close()
+
+	const batchSize = 10000
+
+	p.progress(StageCompact, target.name, 0, 0, "rewriting %s into %s", target.name, outDir)
+	batch := out.NewBatch()
+	n := 0
+	for ; itr.Valid(); itr.Next() {
+		if err := batch.Set(itr.Key(), itr.Value()); err != nil {
+			batch.Close()
+			return err
+		}
+		n++
+		if n%batchSize == 0 {
+			if err := batch.Write(); err != nil {
+				batch.Close()
+				return err
+			}
+			batch.Close()
+			if ctx.Err() != nil {
+				return nil
+			}
+			batch = out.NewBatch()
+		}
+		if n%(100*batchSize) == 0 {
+			p.progress(StageCompact, target.name, int64(n), 0, "rewriting %s: %d keys", target.name, n)
+		}
+	}
+	defer batch.Close()
+
+	if err := itr.Error(); err != nil {
+		return err
+	}
+
+	if err := batch.WriteSync(); err != nil {
+		return err
+	}
+
+	// the copy is written in key order, so compact it once to settle it into the final levels
+	return out.ForceCompact(nil, nil)
+}
+
+// replaceDB moves the db at src in place of the db at dst. src is first moved, or copied when it
+// is on another filesystem, next to dst, so that dst is only replaced once the new db is complete.
+func replaceDB(src, dst string) error {
+	next, prev := dst+".new", dst+".old"
+	for _, path := range []string{next, prev} {
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(src, next); err != nil {
+		if err := copyDir(src, next); err != nil {
+			os.RemoveAll(next)
+			return fmt.Errorf("failed to copy %s next to %s, the rewritten db is left in %s: %w", src, dst, src, err)
+		}
+		if err := os.RemoveAll(src); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(dst, prev); err != nil {
+		return fmt.Errorf("failed to move %s aside, the rewritten db is left in %s: %w", dst, next, err)
+	}
+	if err := os.Rename(next, dst); err != nil {
+		if restoreErr := os.Rename(prev, dst); restoreErr != nil {
+			return fmt.Errorf("failed to move %s into place, the original db is left in %s: %w", next, prev, err)
+		}
+		return fmt.Errorf("failed to move %s into place, the rewritten db is left in %s: %w", next, next, err)
+	}
+
+	return os.RemoveAll(prev)
+}
+
+// copyDir copies the regular files of the directory src into a new directory dst
+func copyDir(src, dst string) error {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		if err := copyFile(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// appStoreRanges splits the application db at every IAVL store prefix s/k:<name>/ so each store
+// is compacted on its own, the ranges before and after cover the multistore metadata.
+func appStoreRanges(appDB db.DB) ([]dbutil.KeyRange, error) {
+	names, err := rootmulti.GetStoreNames(appDB)
+	if err != nil {
+		return nil, err
+	}
+
+	prefixes := make([]string, 0, len(names))
+	for _, name := range names {
+		prefixes = append(prefixes, "s/k:"+name+"/")
+	}
+
+	return dbutil.SplitRanges(prefixes), nil
+}
+
+// blockStoreRanges splits the block store at the prefixes used by the tendermint block store
+func blockStoreRanges(_ db.DB) ([]dbutil.KeyRange, error) {
+	return dbutil.SplitRanges([]string{"BH:", "C:", "H:", "P:", "SC:"}), nil
+}
@@ -0,0 +1,30 @@
+package pruner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplaceDB(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "out", "state.db"), filepath.Join(dir, "state.db")
+	require.NoError(t, os.MkdirAll(src, 0755))
+	require.NoError(t, os.MkdirAll(dst, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "000001.ldb"), []byte("compacted"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dst, "000001.ldb"), []byte("original"), 0644))
+
+	require.NoError(t, replaceDB(src, dst))
+	data, err := os.ReadFile(filepath.Join(dst, "000001.ldb"))
+	require.NoError(t, err)
+	require.Equal(t, "compacted", string(data))
+	require.NoDirExists(t, src)
+	require.NoDirExists(t, dst+".new")
+	require.NoDirExists(t, dst+".old")
+
+	// the original is kept when the rewritten db can not be moved next to it
+	require.Error(t, replaceDB(filepath.Join(dir, "missing.db"), dst))
+	require.FileExists(t, filepath.Join(dst, "000001.ldb"))
+}
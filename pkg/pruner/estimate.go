@@ -0,0 +1,411 @@
+package pruner
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	tmstore "github.com/tendermint/tendermint/store"
+	db "github.com/tendermint/tm-db"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/dbutil"
+	"github.com/binaryholdings/cosmos-pruner/internal/iavldb"
+)
+
+// StoreEstimate is the expected amount of data removed from a single store
+type StoreEstimate struct {
+	Name string
+	// Versions is the amount of versions of the store, Pruned the amount of them to be pruned
+	Versions int
+	Pruned   int
+	// OrphanBytes and RootBytes are measured, NodeBytes and Keys are extrapolated from Sampled
+	// orphans
+	OrphanBytes int64
+	RootBytes   int64
+	NodeBytes   int64
+	Keys        int64
+	Sampled     int64
+}
+
+// Bytes returns the bytes removed from the store
+func (e StoreEstimate) Bytes() int64 {
+	return e.OrphanBytes + e.RootBytes + e.NodeBytes
+}
+
+// TendermintEstimate is the expected amount of data removed from the block and state store
+type TendermintEstimate struct {
+	BlockBytes int64
+	StateBytes int64
+	Keys       int64
+}
+
+// Estimate is the expected outcome of pruning
+type Estimate struct {
+	// Stores are the estimates of the stores to be pruned if CosmosSDK is set, sorted by name
+	Stores []StoreEstimate
+	// Tendermint is the estimate of the tendermint data if Tendermint is set
+	Tendermint *TendermintEstimate
+	// DeleteRate is the measured deletes per second on the filesystem of the data dir
+	DeleteRate float64
+}
+
+// Bytes returns the bytes removed from all dbs
+func (e *Estimate) Bytes() int64 {
+	var bytes int64
+	for _, s := range e.Stores {
+		bytes += s.Bytes()
+	}
+	if e.Tendermint != nil {
+		bytes += e.Tendermint.BlockBytes + e.Tendermint.StateBytes
+	}
+
+	return bytes
+}
+
+// Keys returns the keys deleted from all dbs
+func (e *Estimate) Keys() int64 {
+	var keys int64
+	for _, s := range e.Stores {
+		keys += s.Keys
+	}
+	if e.Tendermint != nil {
+		keys += e.Tendermint.Keys
+	}
+
+	return keys
+}
+
+// Duration returns the time deleting the keys takes at the delete rate
+func (e *Estimate) Duration() time.Duration {
+	if e.DeleteRate <= 0 {
+		return 0
+	}
+	return time.Duration(float64(e.Keys()) / e.DeleteRate * float64(time.Second))
+}
+
+// Estimate extrapolates the data PruneApp and PruneTendermint would remove from up to samples
+// orphans per store, sampled across the versions to be pruned, and from samples blocks, without
+// modifying the data. The time it takes follows from deletes measured in a temporary db in the
+// data dir, which is removed afterwards. It stops between stores once ctx is done.
+func (p *Pruner) Estimate(ctx context.Context, samples int) (*Estimate, error) {
+	if samples < 1 {
+		return nil, errors.New("samples must be at least 1")
+	}
+
+	if p.opts.ProtectUpgrades {
+		if _, err := p.Upgrades(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if p.opts.ProtectSnapshots && p.opts.CosmosSDK {
+		if _, err := p.Snapshots(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	estimate := &Estimate{}
+
+	if p.opts.CosmosSDK {
+		o := opt.Options{
+			DisableSeeksCompaction: true,
+			ReadOnly:               true,
+		}
+
+		appDB, err := db.NewGoLevelDBWithOpts("application", p.dbDir(), &o)
+		if err != nil {
+			return nil, err
+		}
+		defer appDB.Close()
+
+		for _, name := range p.StoreNames() {
+			if err := ctx.Err(); err != nil {
+				return estimate, err
+			}
+
+			p.progress(StageEstimate, name, 0, 0, "sampling store: %s", name)
+			e, err := p.estimateStore(appDB, name, samples)
+			if err != nil {
+				return nil, err
+			}
+			estimate.Stores = append(estimate.Stores, e)
+		}
+	}
+
+	if p.opts.Tendermint {
+		var err error
+		if estimate.Tendermint, err = p.estimateTendermint(samples); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	estimate.DeleteRate, err = measureDeleteRate(p.dbDir())
+
+	return estimate, err
+}
+
+// estimateStore measures the orphan and root ranges of the pruned versions of a store, and
+// extrapolates the sizes of the nodes referred by a sample of these orphans to the whole range.
+func (p *Pruner) estimateStore(appDB db.DB, name string, samples int) (StoreEstimate, error) {
+	prefix := []byte("s/k:" + name + "/")
+
+	versions, _, err := iavldb.Roots(db.NewPrefixDB(appDB, prefix))
+	if err != nil {
+		return StoreEstimate{}, err
+	}
+	pruned := p.PruneHeights(name, versions)
+
+	e := StoreEstimate{Name: name, Versions: len(versions), Pruned: len(pruned)}
+	if len(pruned) == 0 {
+		return e, nil
+	}
+
+	intervals := versionIntervals(pruned)
+	orphanRanges := make([]dbutil.KeyRange, 0, len(intervals))
+	rootRanges := make([]dbutil.KeyRange, 0, len(intervals))
+	for _, iv := range intervals {
+		orphanRanges = append(orphanRanges, versionRange(prefix, 'o', iv[0], iv[1]))
+		rootRanges = append(rootRanges, versionRange(prefix, 'r', iv[0], iv[1]))
+	}
+
+	if e.OrphanBytes, err = dbutil.RangeSize(appDB, orphanRanges); err != nil {
+		return e, err
+	}
+	if e.RootBytes, err = dbutil.RangeSize(appDB, rootRanges); err != nil {
+		return e, err
+	}
+
+	// the node of an orphan is deleted if it was created after the last kept version before
+	// the pruned interval, otherwise only the orphan record is moved. The orphans are sampled at
+	// evenly spaced versions across all pruned versions, as churn and node sizes differ over time.
+	isPruned := make(map[int64]bool, len(pruned))
+	for _, v := range pruned {
+		isPruned[v] = true
+	}
+	points := samples
+	if points > len(pruned) {
+		points = len(pruned)
+	}
+	perPoint := samples / points
+
+	var sampled, sampledBytes, deleted, deletedBytes int64
+	for k := 0; k < points; k++ {
+		version := pruned[k*len(pruned)/points]
+		iv := intervals[sort.Search(len(intervals), func(i int) bool { return intervals[i][1] > version })]
+		predecessor := int64(0)
+		for j := sort.Search(len(versions), func(i int) bool { return versions[i] >= iv[0] }) - 1; j >= 0; j-- {
+			if !isPruned[versions[j]] {
+				predecessor = versions[j]
+				break
+			}
+		}
+
+		r := versionRange(prefix, 'o', version, iv[1])
+		itr, err := appDB.Iterator(r.Start, r.End)
+		if err != nil {
+			return e, err
+		}
+		for n := 0; itr.Valid() && n < perPoint; itr.Next() {
+			key, hash := itr.Key(), itr.Value()
+			n++
+			sampled++
+			sampledBytes += int64(len(key) + len(hash))
+
+			from := int64(binary.BigEndian.Uint64(key[len(prefix)+9 : len(prefix)+17]))
+			if from <= predecessor {
+				continue
+			}
+
+			node, err := appDB.Get(append(append(append([]byte{}, prefix...), 'n'), hash...))
+			if err != nil {
+				itr.Close()
+				return e, err
+			}
+			deleted++
+			deletedBytes += int64(len(prefix) + 1 + len(hash) + len(node))
+		}
+		if err := itr.Close(); err != nil {
+			return e, err
+		}
+	}
+	e.Sampled = sampled
+
+	if sampledBytes > 0 {
+		orphans := e.OrphanBytes * sampled / sampledBytes
+		e.NodeBytes = e.OrphanBytes * deletedBytes / sampledBytes
+		e.Keys = orphans + orphans*deleted/sampled + int64(len(pruned))
+	}
+
+	return e, nil
+}
+
+// estimateTendermint estimates the bytes removed from the block and state store by sampling the
+// sizes of the blocks within and outside of the pruned ranges
+func (p *Pruner) estimateTendermint(samples int) (*TendermintEstimate, error) {
+	dbDir := p.dbDir()
+	estimate := &TendermintEstimate{}
+	if p.opts.KeepBlocks == 0 {
+		return estimate, nil
+	}
+
+	o := opt.Options{
+		DisableSeeksCompaction: true,
+		ReadOnly:               true,
+	}
+
+	blockStoreDB, err := db.NewGoLevelDBWithOpts("blockstore", dbDir, &o)
+	if err != nil {
+		return nil, err
+	}
+	blockStore := tmstore.NewBlockStore(blockStoreDB)
+	defer blockStore.Close()
+
+	base, height := blockStore.Base(), blockStore.Height()
+	ranges := p.BlockPruneRanges(base, height)
+	pruned := int64(0)
+	for _, r := range ranges {
+		pruned += r.To - r.From + 1
+	}
+	if pruned == 0 {
+		return estimate, nil
+	}
+
+	step := (height - base + 1) / int64(samples)
+	if step == 0 {
+		step = 1
+	}
+
+	var prunedBytes, total, prunedKeys, sampledPruned int64
+	for h := base; h <= height; h += step {
+		meta := blockStore.LoadBlockMeta(h)
+		if meta == nil {
+			continue
+		}
+		total += int64(meta.BlockSize)
+		if inHeightRanges(ranges, h) {
+			prunedBytes += int64(meta.BlockSize)
+			// block meta, hash, commit, seen commit and the parts
+			prunedKeys += 4 + int64(meta.BlockID.PartSetHeader.Total)
+			sampledPruned++
+		}
+	}
+
+	blockSize, err := dbutil.DirSize(filepath.Join(dbDir, "blockstore.db"))
+	if err != nil {
+		return nil, err
+	}
+	stateSize, err := dbutil.DirSize(filepath.Join(dbDir, "state.db"))
+	if err != nil {
+		return nil, err
+	}
+
+	if total > 0 {
+		estimate.BlockBytes = blockSize * prunedBytes / total
+	}
+	estimate.StateBytes = stateSize * pruned / (height - base + 1)
+	if sampledPruned > 0 {
+		// validators, consensus params and abci responses for every height
+		estimate.Keys = (prunedKeys/sampledPruned + 3) * pruned
+	}
+
+	return estimate, nil
+}
+
+// versionIntervals groups sorted versions into [from, to) intervals of consecutive versions
+func versionIntervals(versions []int64) [][2]int64 {
+	intervals := make([][2]int64, 0)
+	for _, v := range versions {
+		if n := len(intervals); n > 0 && intervals[n-1][1] == v {
+			intervals[n-1][1] = v + 1
+			continue
+		}
+		intervals = append(intervals, [2]int64{v, v + 1})
+	}
+
+	return intervals
+}
+
+// versionRange returns the range of IAVL records <kind><version> with version in [from, to)
+func versionRange(prefix []byte, kind byte, from, to int64) dbutil.KeyRange {
+	key := func(v int64) []byte {
+		k := make([]byte, len(prefix)+9)
+		copy(k, prefix)
+		k[len(prefix)] = kind
+		binary.BigEndian.PutUint64(k[len(prefix)+1:], uint64(v))
+		return k
+	}
+
+	return dbutil.KeyRange{
+		Name:  fmt.Sprintf("%s%c%d-%d", prefix, kind, from, to),
+		Start: key(from),
+		End:   key(to),
+	}
+}
+
+// measureDeleteRate writes and deletes keys in batches in a temporary db in dbDir, so that it is
+// measured on the disk of the node databases, and returns the measured deletes per second. The
+// temporary db is removed afterwards.
+func measureDeleteRate(dbDir string) (float64, error) {
+	const (
+		batches   = 20
+		batchSize = 10000
+	)
+
+	tmpDir, err := os.MkdirTemp(dbDir, "estimate-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tdb, err := db.NewGoLevelDB("estimate", tmpDir)
+	if err != nil {
+		return 0, err
+	}
+	defer tdb.Close()
+
+	value := make([]byte, 128)
+	keyAt := func(i int) []byte {
+		k := make([]byte, 40)
+		binary.BigEndian.PutUint64(k, uint64(i)*0x9E3779B97F4A7C15)
+		return k
+	}
+
+	for b := 0; b < batches; b++ {
+		batch := tdb.NewBatch()
+		for i := b * batchSize; i < (b+1)*batchSize; i++ {
+			if err := batch.Set(keyAt(i), value); err != nil {
+				batch.Close()
+				return 0, err
+			}
+		}
+		if err := batch.Write(); err != nil {
+			batch.Close()
+			return 0, err
+		}
+		batch.Close()
+	}
+
+	start := time.Now()
+	for b := 0; b < batches; b++ {
+		batch := tdb.NewBatch()
+		for i := b * batchSize; i < (b+1)*batchSize; i++ {
+			if err := batch.Delete(keyAt(i)); err != nil {
+				batch.Close()
+				return 0, err
+			}
+		}
+		if err := batch.Write(); err != nil {
+			batch.Close()
+			return 0, err
+		}
+		batch.Close()
+	}
+
+	return float64(batches*batchSize) / time.Since(start).Seconds(), nil
+}
@@ -0,0 +1,98 @@
+package pruner
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// HeightRange is the heights from From up to and including To. It is written as "from-to", or as
+// a single height when From and To are equal.
+type HeightRange struct {
+	From int64
+	To   int64
+}
+
+func (r HeightRange) String() string {
+	if r.From == r.To {
+		return strconv.FormatInt(r.From, 10)
+	}
+	return fmt.Sprintf("%d-%d", r.From, r.To)
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (r HeightRange) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (r *HeightRange) UnmarshalText(text []byte) error {
+	from, to := string(text), string(text)
+	if i := strings.Index(from, "-"); i > 0 {
+		from, to = from[:i], from[i+1:]
+	}
+
+	var err error
+	if r.From, err = strconv.ParseInt(from, 10, 64); err != nil {
+		return fmt.Errorf("invalid height range %q", text)
+	}
+	if r.To, err = strconv.ParseInt(to, 10, 64); err != nil {
+		return fmt.Errorf("invalid height range %q", text)
+	}
+	if r.From < 1 || r.To < r.From {
+		return fmt.Errorf("invalid height range %q", text)
+	}
+
+	return nil
+}
+
+// ParseHeightRanges parses heights and ranges of heights separated by commas or whitespace, e.g.
+// "100-5000,7000". Lines starting with # are comments. The ranges are returned sorted, with
+// overlapping and adjacent ranges merged.
+func ParseHeightRanges(s string) ([]HeightRange, error) {
+	ranges := make([]HeightRange, 0)
+	for _, line := range strings.Split(s, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\r'
+		})
+		for _, field := range fields {
+			var r HeightRange
+			if err := r.UnmarshalText([]byte(field)); err != nil {
+				return nil, err
+			}
+			ranges = append(ranges, r)
+		}
+	}
+
+	return mergeHeightRanges(ranges), nil
+}
+
+// mergeHeightRanges returns the ranges sorted, with overlapping and adjacent ranges merged
+func mergeHeightRanges(ranges []HeightRange) []HeightRange {
+	sorted := append([]HeightRange{}, ranges...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].From < sorted[j].From })
+
+	merged := make([]HeightRange, 0, len(sorted))
+	for _, r := range sorted {
+		if n := len(merged); n > 0 && r.From <= merged[n-1].To+1 {
+			if r.To > merged[n-1].To {
+				merged[n-1].To = r.To
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+
+	return merged
+}
+
+// inHeightRanges returns whether a height is within the sorted, disjoint ranges
+func inHeightRanges(ranges []HeightRange, height int64) bool {
+	i := sort.Search(len(ranges), func(i int) bool { return ranges[i].To >= height })
+	return i < len(ranges) && ranges[i].From <= height
+}
@@ -0,0 +1,44 @@
+package pruner
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeightRangeText(t *testing.T) {
+	bz, err := json.Marshal([]HeightRange{{100, 5000}, {7000, 7000}})
+	require.NoError(t, err)
+	require.Equal(t, `["100-5000","7000"]`, string(bz))
+
+	var ranges []HeightRange
+	require.NoError(t, json.Unmarshal(bz, &ranges))
+	require.Equal(t, []HeightRange{{100, 5000}, {7000, 7000}}, ranges)
+
+	for _, invalid := range []string{`["5000-100"]`, `["0"]`, `["-5"]`, `["1-"]`, `["a-b"]`} {
+		require.Error(t, json.Unmarshal([]byte(invalid), &ranges), invalid)
+	}
+}
+
+func TestParseHeightRanges(t *testing.T) {
+	ranges, err := ParseHeightRanges("7000,100-5000, 4000-6000\n# incident\n6001 9000-9000\n")
+	require.NoError(t, err)
+	require.Equal(t, []HeightRange{{100, 6001}, {7000, 7000}, {9000, 9000}}, ranges)
+
+	ranges, err = ParseHeightRanges("")
+	require.NoError(t, err)
+	require.Empty(t, ranges)
+
+	_, err = ParseHeightRanges("100-5000,x")
+	require.Error(t, err)
+}
+
+func TestHeightRangesLookup(t *testing.T) {
+	ranges := []HeightRange{{10, 20}, {30, 30}}
+	require.True(t, inHeightRanges(ranges, 10))
+	require.True(t, inHeightRanges(ranges, 30))
+	require.False(t, inHeightRanges(ranges, 25))
+	require.False(t, inHeightRanges(ranges, 31))
+	require.False(t, inHeightRanges(nil, 1))
+}
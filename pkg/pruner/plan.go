@@ -0,0 +1,384 @@
+package pruner
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	tmstore "github.com/tendermint/tendermint/store"
+	db "github.com/tendermint/tm-db"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/iavldb"
+	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+)
+
+// PlanFormat is the version of the plan file format written by Plan.WriteFile
+const PlanFormat = 1
+
+// Plan is the exact set of versions and heights a Pruner deletes and the dbs it compacts, along
+// with the fingerprint of the data it was computed against. A Pruner with Options.Plan applies it.
+type Plan struct {
+	Format  int       `json:"format"`
+	Created time.Time `json:"created"`
+	// DataDir, App and the retention are what the plan was computed with, for its reviewers
+	DataDir    string `json:"data-dir"`
+	App        string `json:"app"`
+	KeepRecent uint64 `json:"pruning-keep-recent"`
+	KeepEvery  uint64 `json:"pruning-keep-every"`
+	KeepBlocks uint64 `json:"min-retain-blocks"`
+	// PruneHeights and KeepHeights are the versions explicitly pruned and kept
+	PruneHeights []HeightRange `json:"prune-heights,omitempty"`
+	KeepHeights  []HeightRange `json:"keep-heights,omitempty"`
+	// Upgrades are the completed upgrade plans kept with UpgradeWindow heights around each
+	Upgrades      []Upgrade `json:"upgrades,omitempty"`
+	UpgradeWindow uint64    `json:"upgrade-window,omitempty"`
+	// Snapshots are the heights of the local snapshots kept, along with the latest
+	// SnapshotKeepRecent multiples of SnapshotInterval
+	Snapshots          []int64 `json:"snapshots,omitempty"`
+	SnapshotInterval   uint64  `json:"snapshot-interval,omitempty"`
+	SnapshotKeepRecent uint64  `json:"snapshot-keep-recent,omitempty"`
+
+	Fingerprint Fingerprint `json:"fingerprint"`
+
+	// Stores are the stores of the application state sorted by name, nil if it is not pruned
+	Stores []StorePlan `json:"stores"`
+	// Tendermint is what is deleted from the tendermint dbs, nil if they are not pruned
+	Tendermint *TendermintPlan `json:"tendermint,omitempty"`
+	// Compact are the dbs compacted after pruning, a plan being applied compacts no others
+	Compact []string `json:"compact"`
+}
+
+// StorePlan is the versions of a single store to be deleted
+type StorePlan struct {
+	Name string `json:"name"`
+	// Versions is the amount of versions of the store, Delete of them are deleted
+	Versions int `json:"versions"`
+	Delete   int `json:"delete"`
+	// Heights are the ranges of versions deleted, every version of the store within them is
+	Heights []HeightRange `json:"heights"`
+}
+
+// TendermintPlan is the blocks and states to be deleted, and the height expired evidence is
+// deleted below
+type TendermintPlan struct {
+	PruneHeight int64 `json:"prune-height"`
+	// Blocks and States are the ranges of heights deleted, split by the protected upgrade heights
+	Blocks []HeightRange `json:"blocks,omitempty"`
+	States []HeightRange `json:"states,omitempty"`
+}
+
+// Fingerprint identifies the state of the data of a node a plan is computed against
+type Fingerprint struct {
+	LatestVersion int64                       `json:"latest-version,omitempty"`
+	Stores        map[string]StoreFingerprint `json:"stores,omitempty"`
+	BlockBase     int64                       `json:"block-base,omitempty"`
+	BlockHeight   int64                       `json:"block-height,omitempty"`
+}
+
+// StoreFingerprint identifies the versions of a single store
+type StoreFingerprint struct {
+	Versions int    `json:"versions"`
+	First    int64  `json:"first"`
+	Latest   int64  `json:"latest"`
+	Hash     string `json:"hash"`
+}
+
+// PlanMismatchError is returned by CheckPlan when the data changed since the plan was computed
+type PlanMismatchError struct {
+	Changes []string
+}
+
+func (e *PlanMismatchError) Error() string {
+	return fmt.Sprintf("the data changed since the plan was made: %s", strings.Join(e.Changes, "; "))
+}
+
+// Plan computes what PruneApp and PruneTendermint would delete without modifying any data. The
+// application state is planned if CosmosSDK is set, and the tendermint dbs if Tendermint is set
+// and KeepBlocks is not 0.
+func (p *Pruner) Plan(ctx context.Context) (*Plan, error) {
+	plan := &Plan{
+		Format:       PlanFormat,
+		Created:      time.Now().UTC(),
+		DataDir:      p.dbDir(),
+		App:          p.opts.App.Name(),
+		KeepRecent:   p.opts.KeepRecent,
+		KeepEvery:    p.opts.KeepEvery,
+		KeepBlocks:   p.opts.KeepBlocks,
+		PruneHeights: p.opts.PruneHeights,
+		KeepHeights:  p.opts.KeepHeights,
+		Compact:      []string{},
+	}
+	if abs, err := filepath.Abs(plan.DataDir); err == nil {
+		plan.DataDir = abs
+	}
+
+	planTendermint := p.opts.Tendermint && p.opts.KeepBlocks > 0
+	if planTendermint && p.opts.KeepBlocks < MinKeepBlocks {
+		return nil, fmt.Errorf("Your min-retain-blocks %+v is lower than the minimum %d", p.opts.KeepBlocks, MinKeepBlocks)
+	}
+
+	if p.opts.ProtectUpgrades {
+		upgrades, err := p.Upgrades(ctx)
+		if err != nil {
+			return nil, err
+		}
+		plan.Upgrades, plan.UpgradeWindow = upgrades, p.opts.UpgradeWindow
+	}
+	if p.opts.ProtectSnapshots && p.opts.CosmosSDK {
+		snapshots, err := p.Snapshots(ctx)
+		if err != nil {
+			return nil, err
+		}
+		plan.Snapshots = make([]int64, 0, len(snapshots))
+		for _, s := range snapshots {
+			plan.Snapshots = append(plan.Snapshots, s.Height)
+		}
+		plan.SnapshotInterval, plan.SnapshotKeepRecent = p.opts.SnapshotInterval, p.opts.SnapshotKeepRecent
+	}
+
+	var names []string
+	if p.opts.CosmosSDK {
+		names = p.StoreNames()
+	}
+	fingerprint, versions, err := p.fingerprint(ctx, names, planTendermint)
+	if err != nil {
+		return nil, err
+	}
+	plan.Fingerprint = *fingerprint
+
+	if p.opts.CosmosSDK {
+		if err := p.checkPruneHeights(fingerprint.LatestVersion); err != nil {
+			return nil, err
+		}
+
+		plan.Stores = make([]StorePlan, 0)
+		for _, name := range names {
+			v := versions[name]
+			heights := p.PruneHeights(name, v)
+			// like iavl, the latest version is never deleted
+			if len(heights) > 0 && heights[len(heights)-1] == v[len(v)-1] {
+				heights = heights[:len(heights)-1]
+			}
+			plan.Stores = append(plan.Stores, StorePlan{
+				Name:     name,
+				Versions: len(v),
+				Delete:   len(heights),
+				Heights:  heightRanges(v, heights),
+			})
+		}
+		plan.Compact = append(plan.Compact, "application")
+	}
+
+	if planTendermint {
+		plan.Tendermint = &TendermintPlan{
+			PruneHeight: p.BlockPruneHeight(fingerprint.BlockBase, fingerprint.BlockHeight),
+			Blocks:      p.BlockPruneRanges(fingerprint.BlockBase, fingerprint.BlockHeight),
+			States:      p.BlockPruneRanges(fingerprint.BlockBase, fingerprint.BlockHeight),
+		}
+		plan.Compact = append(plan.Compact, "evidence", "blockstore", "state")
+	}
+
+	return plan, nil
+}
+
+// CheckPlan returns a *PlanMismatchError if the data changed since the plan was computed, so that
+// the plan no longer deletes what it was reviewed to delete
+func (p *Pruner) CheckPlan(ctx context.Context, plan *Plan) error {
+	if plan.Format != PlanFormat {
+		return fmt.Errorf("unsupported plan format %d, expected %d", plan.Format, PlanFormat)
+	}
+
+	var names []string
+	for _, s := range plan.Stores {
+		names = append(names, s.Name)
+	}
+	current, _, err := p.fingerprint(ctx, names, plan.Tendermint != nil)
+	if err != nil {
+		return err
+	}
+
+	changes := []string{}
+	if dbDir, err := filepath.Abs(p.dbDir()); err == nil && plan.DataDir != "" && dbDir != plan.DataDir {
+		changes = append(changes, fmt.Sprintf("data dir is %s, planned for %s", dbDir, plan.DataDir))
+	}
+	want := plan.Fingerprint
+	if len(names) > 0 && want.LatestVersion != current.LatestVersion {
+		changes = append(changes, fmt.Sprintf("latest version is %d, planned at %d", current.LatestVersion, want.LatestVersion))
+	}
+	for _, s := range plan.Stores {
+		got, planned := current.Stores[s.Name], want.Stores[s.Name]
+		if got.Versions != planned.Versions || got.First != planned.First || got.Latest != planned.Latest {
+			changes = append(changes, fmt.Sprintf("store %s has %s, planned at %s", s.Name, got, planned))
+		} else if got.Hash != planned.Hash {
+			changes = append(changes, fmt.Sprintf("store %s has another root at version %d", s.Name, got.Latest))
+		}
+	}
+	if want.BlockBase != current.BlockBase || want.BlockHeight != current.BlockHeight {
+		changes = append(changes, fmt.Sprintf("blocks are %d-%d, planned at %d-%d",
+			current.BlockBase, current.BlockHeight, want.BlockBase, want.BlockHeight))
+	}
+
+	if len(changes) > 0 {
+		return &PlanMismatchError{Changes: changes}
+	}
+
+	return nil
+}
+
+func (f StoreFingerprint) String() string {
+	return fmt.Sprintf("%d versions %d-%d", f.Versions, f.First, f.Latest)
+}
+
+// fingerprint reads the fingerprint of the given stores of the application state unless there are
+// none, and of the block store if blocks is set. It also returns the versions of the stores.
+func (p *Pruner) fingerprint(ctx context.Context, names []string, blocks bool) (*Fingerprint, map[string][]int64, error) {
+	dbDir := p.dbDir()
+	fingerprint := &Fingerprint{}
+	versions := make(map[string][]int64)
+
+	o := opt.Options{
+		DisableSeeksCompaction: true,
+		ReadOnly:               true,
+	}
+
+	if len(names) > 0 {
+		appDB, err := db.NewGoLevelDBWithOpts("application", dbDir, &o)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer appDB.Close()
+
+		if fingerprint.LatestVersion, err = rootmulti.GetLatestVersion(appDB); err != nil {
+			return nil, nil, err
+		}
+
+		fingerprint.Stores = make(map[string]StoreFingerprint)
+		for _, name := range names {
+			if err := ctx.Err(); err != nil {
+				return nil, nil, err
+			}
+
+			v, roots, err := iavldb.Roots(db.NewPrefixDB(appDB, []byte("s/k:"+name+"/")))
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to read versions of store %s: %w", name, err)
+			}
+
+			f := StoreFingerprint{Versions: len(v)}
+			if len(v) > 0 {
+				f.First, f.Latest = v[0], v[len(v)-1]
+				f.Hash = hex.EncodeToString(roots[f.Latest])
+			}
+			fingerprint.Stores[name] = f
+			versions[name] = v
+		}
+	}
+
+	if blocks {
+		blockStoreDB, err := db.NewGoLevelDBWithOpts("blockstore", dbDir, &o)
+		if err != nil {
+			return nil, nil, err
+		}
+		blockStore := tmstore.NewBlockStore(blockStoreDB)
+		defer blockStore.Close()
+
+		fingerprint.BlockBase, fingerprint.BlockHeight = blockStore.Base(), blockStore.Height()
+	}
+
+	return fingerprint, versions, nil
+}
+
+// storeHeights returns the sorted versions of a store to be deleted, those within the ranges of
+// Options.Plan if it is set and PruneHeights otherwise
+func (p *Pruner) storeHeights(store string, versions []int64) []int64 {
+	if p.opts.Plan == nil {
+		return p.PruneHeights(store, versions)
+	}
+
+	heights := make([]int64, 0)
+	for _, s := range p.opts.Plan.Stores {
+		if s.Name != store {
+			continue
+		}
+		for _, v := range versions {
+			if inHeightRanges(s.Heights, v) {
+				heights = append(heights, v)
+			}
+		}
+	}
+
+	return heights
+}
+
+// heightRanges returns the ranges of consecutive versions the sorted heights, a subset of the
+// sorted versions, make up
+func heightRanges(versions, heights []int64) []HeightRange {
+	ranges := make([]HeightRange, 0)
+
+	j := 0
+	consecutive := false
+	for _, v := range versions {
+		if j == len(heights) {
+			break
+		}
+		if heights[j] != v {
+			consecutive = false
+			continue
+		}
+
+		if consecutive {
+			ranges[len(ranges)-1].To = v
+		} else {
+			ranges = append(ranges, HeightRange{From: v, To: v})
+		}
+		consecutive = true
+		j++
+	}
+
+	return ranges
+}
+
+// ReadPlanFile reads a plan written by Plan.WriteFile
+func ReadPlanFile(path string) (*Plan, error) {
+	bz, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{}
+	if err := json.Unmarshal(bz, plan); err != nil {
+		return nil, fmt.Errorf("failed to read plan %s: %w", path, err)
+	}
+
+	return plan, nil
+}
+
+// WriteFile writes the plan as indented JSON
+func (plan *Plan) WriteFile(path string) error {
+	bz, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(bz, '\n'), 0644)
+}
+
+// compacts returns whether the db is compacted after pruning, which the plan decides if there is one
+func (p *Pruner) compacts(name string) bool {
+	if p.opts.Plan == nil {
+		return true
+	}
+
+	for _, c := range p.opts.Plan.Compact {
+		if c == name {
+			return true
+		}
+	}
+
+	return false
+}
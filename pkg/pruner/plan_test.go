@@ -0,0 +1,162 @@
+package pruner
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	tmstore "github.com/tendermint/tendermint/store"
+	tmtypes "github.com/tendermint/tendermint/types"
+	db "github.com/tendermint/tm-db"
+)
+
+func TestHeightRanges(t *testing.T) {
+	versions := []int64{1, 2, 3, 5, 6, 8, 9, 10}
+
+	require.Equal(t, []HeightRange{{1, 5}, {8, 8}}, heightRanges(versions, []int64{1, 2, 3, 5, 8}))
+	require.Equal(t, []HeightRange{{2, 2}, {6, 9}}, heightRanges(versions, []int64{2, 6, 8, 9}))
+	require.Empty(t, heightRanges(versions, nil))
+}
+
+func TestStoreHeightsOfPlan(t *testing.T) {
+	versions := []int64{1, 2, 3, 5, 6, 8, 9, 10}
+	plan := &Plan{
+		Format: PlanFormat,
+		Stores: []StorePlan{
+			{Name: "acc", Heights: []HeightRange{{1, 5}, {8, 8}}},
+			{Name: "bank", Heights: []HeightRange{}},
+		},
+	}
+
+	p, err := New(Options{Home: "/node", Plan: plan})
+	require.NoError(t, err)
+	require.Equal(t, []string{"acc", "bank"}, p.StoreNames())
+	require.Equal(t, []int64{1, 2, 3, 5, 8}, p.storeHeights("acc", versions))
+	require.Empty(t, p.storeHeights("bank", versions))
+	require.Empty(t, p.storeHeights("staking", versions))
+
+	path := filepath.Join(t.TempDir(), "plan.json")
+	require.NoError(t, plan.WriteFile(path))
+	read, err := ReadPlanFile(path)
+	require.NoError(t, err)
+	require.Equal(t, plan.Stores, read.Stores)
+
+	// an empty store list prunes no stores, unlike a plan without the application state
+	empty := &Plan{Format: PlanFormat, Stores: []StorePlan{}, Compact: []string{"blockstore"}}
+	require.NoError(t, empty.WriteFile(path))
+	read, err = ReadPlanFile(path)
+	require.NoError(t, err)
+	require.NotNil(t, read.Stores)
+	require.Empty(t, read.Stores)
+
+	p, err = New(Options{Home: "/node", Plan: read})
+	require.NoError(t, err)
+	require.True(t, p.compacts("blockstore"))
+	require.False(t, p.compacts("application"))
+}
+
+// saveBlocks saves the blocks 1 to height into the block store of dataDir
+func saveBlocks(t *testing.T, dataDir string, height int64) {
+	blockStoreDB, err := db.NewGoLevelDB("blockstore", dataDir)
+	require.NoError(t, err)
+	blockStore := tmstore.NewBlockStore(blockStoreDB)
+	defer blockStore.Close()
+
+	for h := int64(1); h <= height; h++ {
+		block := tmtypes.MakeBlock(h, nil, &tmtypes.Commit{Height: h - 1}, nil)
+		block.ProposerAddress = make([]byte, 20)
+		parts := block.MakePartSet(tmtypes.BlockPartSizeBytes)
+		blockStore.SaveBlock(block, parts, &tmtypes.Commit{Height: h, BlockID: tmtypes.BlockID{Hash: block.Hash(), PartSetHeader: parts.Header()}})
+	}
+}
+
+func TestCheckPlan(t *testing.T) {
+	// newNode saves the same application state and block heights into a new home
+	newNode := func() string {
+		home := t.TempDir()
+		saveAppSnapshot(t, filepath.Join(home, "data"), home, 5, 5, "acc")
+		saveBlocks(t, filepath.Join(home, "data"), 10)
+		return home
+	}
+	newPruner := func(home string) *Pruner {
+		p, err := New(Options{
+			Home:       home,
+			App:        NewAppProfile("chain", []string{"acc", "bank"}, Retention{}, nil),
+			KeepRecent: 2,
+			KeepBlocks: MinKeepBlocks,
+			CosmosSDK:  true,
+			Tendermint: true,
+		})
+		require.NoError(t, err)
+		return p
+	}
+	requireMismatch := func(t *testing.T, err error, change string) {
+		var mismatch *PlanMismatchError
+		require.True(t, errors.As(err, &mismatch), "%v", err)
+		require.Equal(t, []string{change}, mismatch.Changes)
+	}
+
+	t.Run("unchanged", func(t *testing.T) {
+		p := newPruner(newNode())
+		plan, err := p.Plan(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, int64(5), plan.Fingerprint.LatestVersion)
+		require.Equal(t, []StorePlan{
+			{Name: "acc", Versions: 5, Delete: 3, Heights: []HeightRange{{1, 3}}},
+			{Name: "bank", Versions: 5, Delete: 3, Heights: []HeightRange{{1, 3}}},
+		}, plan.Stores)
+		require.NoError(t, p.CheckPlan(context.Background(), plan))
+
+		plan.Format = PlanFormat + 1
+		err = p.CheckPlan(context.Background(), plan)
+		require.Error(t, err)
+		var mismatch *PlanMismatchError
+		require.False(t, errors.As(err, &mismatch))
+	})
+
+	t.Run("latest version", func(t *testing.T) {
+		home := newNode()
+		p := newPruner(home)
+		plan, err := p.Plan(context.Background())
+		require.NoError(t, err)
+
+		// the node committed another version after the plan was made
+		saveAppSnapshot(t, filepath.Join(home, "data"), home, 1, 6, "acc")
+		err = p.CheckPlan(context.Background(), plan)
+		var mismatch *PlanMismatchError
+		require.True(t, errors.As(err, &mismatch), "%v", err)
+		require.Equal(t, []string{
+			"latest version is 6, planned at 5",
+			"store acc has 6 versions 1-6, planned at 5 versions 1-5",
+			"store bank has 6 versions 1-6, planned at 5 versions 1-5",
+		}, mismatch.Changes)
+	})
+
+	t.Run("block base", func(t *testing.T) {
+		home := newNode()
+		p := newPruner(home)
+		plan, err := p.Plan(context.Background())
+		require.NoError(t, err)
+
+		blockStoreDB, err := db.NewGoLevelDB("blockstore", filepath.Join(home, "data"))
+		require.NoError(t, err)
+		blockStore := tmstore.NewBlockStore(blockStoreDB)
+		_, err = blockStore.PruneBlocks(4)
+		require.NoError(t, err)
+		require.NoError(t, blockStore.Close())
+
+		requireMismatch(t, p.CheckPlan(context.Background(), plan), "blocks are 4-10, planned at 1-10")
+	})
+
+	t.Run("data dir", func(t *testing.T) {
+		home := newNode()
+		plan, err := newPruner(home).Plan(context.Background())
+		require.NoError(t, err)
+
+		other := newNode()
+		requireMismatch(t, newPruner(other).CheckPlan(context.Background(), plan),
+			"data dir is "+filepath.Join(other, "data")+", planned for "+filepath.Join(home, "data"))
+	})
+}
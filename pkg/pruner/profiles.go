@@ -0,0 +1,49 @@
+package pruner
+
+import (
+	"fmt"
+	"sort"
+)
+
+// PruningProfile is a named retention of the history of a node
+type PruningProfile struct {
+	Name string
+	Retention
+}
+
+// pruningProfiles are the built-in pruning profiles by name
+var pruningProfiles = map[string]Retention{
+	"default":    {KeepRecent: 400000, KeepEvery: 100},
+	"nothing":    {KeepEvery: 1},
+	"everything": {KeepRecent: 10},
+	"emitter":    {KeepRecent: 100, KeepBlocks: 100000},
+	"rest-light": {KeepRecent: 100000, KeepBlocks: 600000},
+	"rest-heavy": {KeepRecent: 400000, KeepEvery: 1000},
+	"peer":       {KeepRecent: 100, KeepEvery: 30000},
+	"seed":       {KeepRecent: 100, KeepBlocks: 100000},
+	"sentry":     {KeepRecent: 100, KeepBlocks: 300000},
+	"validator":  {KeepRecent: 100, KeepBlocks: 100000},
+}
+
+// LookupPruningProfile returns the built-in pruning profile of the given name
+func LookupPruningProfile(name string) (PruningProfile, error) {
+	r, ok := pruningProfiles[name]
+	if !ok {
+		return PruningProfile{}, fmt.Errorf("unknown pruning profile %q", name)
+	}
+
+	return PruningProfile{Name: name, Retention: r}, nil
+}
+
+// PruningProfiles returns the built-in pruning profiles sorted by name
+func PruningProfiles() []PruningProfile {
+	list := make([]PruningProfile, 0, len(pruningProfiles))
+	for name, r := range pruningProfiles {
+		list = append(list, PruningProfile{Name: name, Retention: r})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
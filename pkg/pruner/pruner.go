@@ -0,0 +1,390 @@
+// Package pruner prunes the application state and the tendermint data of a cosmos-sdk node and
+// compacts their databases. The node must be stopped while a Pruner works on its data.
+//
+// Every method stops cleanly between batches once its context is done. It then leaves the data
+// consistent, returns what was done so far along with the error of the context, and a later call
+// continues from there.
+package pruner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cosmos/cosmos-sdk/types"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/dbutil"
+)
+
+// Engine is the way the versions of the application state are deleted
+type Engine string
+
+const (
+	// EngineIAVL deletes versions through the iavl trees of the stores
+	EngineIAVL Engine = "iavl"
+	// EngineOrphanSweep deletes versions by sweeping the orphan and root records of the stores
+	EngineOrphanSweep Engine = "orphan-sweep"
+)
+
+const (
+	// DefaultIAVLCacheSize is the amount of IAVL nodes cached per store unless set otherwise
+	DefaultIAVLCacheSize = 10000
+	// MinKeepBlocks is the least amount of tendermint blocks that can be kept when pruning them
+	MinKeepBlocks = 100000
+)
+
+// Options configure a Pruner, zero values select the defaults
+type Options struct {
+	// Home is the home directory of the node. Its databases are in DataDir, which is relative to
+	// Home unless it is absolute (default "data").
+	Home    string
+	DataDir string
+
+	// App is the application whose stores are pruned, and whose protected heights are kept
+	// (default the DefaultApp profile)
+	App AppProfile
+	// Modules are the names of extra stores to be pruned
+	Modules []string
+
+	// KeepRecent is the amount of latest versions of the application state kept, KeepEvery also
+	// keeps every KeepEvery-th version (0=none)
+	KeepRecent uint64
+	KeepEvery  uint64
+	// KeepBlocks is the amount of latest tendermint blocks kept, at least MinKeepBlocks (0=all)
+	KeepBlocks uint64
+	// Stores overrides KeepRecent and KeepEvery for single stores by name
+	Stores map[string]StoreRetention
+	// PruneHeights are versions of the application state deleted regardless of the retention,
+	// never the latest one. KeepHeights are versions that are never deleted, like the protected
+	// heights of the app, even within PruneHeights.
+	PruneHeights []HeightRange
+	KeepHeights  []HeightRange
+	// ProtectUpgrades keeps the heights of the completed upgrade plans, and UpgradeWindow heights
+	// before and after each, of the application state and of the blocks. The blocks around them
+	// are pruned, which leaves gaps in the block store above its base.
+	ProtectUpgrades bool
+	UpgradeWindow   uint64
+	// ProtectSnapshots keeps the versions of the application state the local state-sync snapshots
+	// were taken at, and the latest SnapshotKeepRecent versions that are multiples of
+	// SnapshotInterval (0=all of them), which the node keeps snapshots of
+	ProtectSnapshots   bool
+	SnapshotInterval   uint64
+	SnapshotKeepRecent uint64
+
+	// Engine deletes the versions of the application state (default EngineIAVL)
+	Engine Engine
+	// Batch is the amount of versions of a store deleted in one batch (0=all at once)
+	Batch uint64
+	// BatchBytes sizes the batches of every store to write about this many bytes instead of
+	// Batch. These batches shrink when one takes longer than BatchLatency, or while the heap in
+	// use is larger than BatchMemory (0=no limit).
+	BatchBytes   int64
+	BatchLatency time.Duration
+	BatchMemory  uint64
+	// Parallel is the amount of stores pruned, or dbs compacted, at once (default 1)
+	Parallel int
+	// StoreWorkers is the amount of goroutines deleting the versions of a single store at once,
+	// more than one requires EngineOrphanSweep (default 1)
+	StoreWorkers int
+	// IAVLCacheSize is the amount of IAVL nodes cached per store (default DefaultIAVLCacheSize)
+	IAVLCacheSize int
+
+	// CosmosSDK and Tendermint select the dbs Compact and Status work on
+	CosmosSDK  bool
+	Tendermint bool
+	// OutDir makes Compact rewrite the dbs into this directory, for example on another disk, and
+	// move them into place instead of compacting them in place
+	OutDir string
+
+	// Plan makes PruneApp and PruneTendermint delete exactly the versions and heights of the plan
+	// instead of those selected by the retention, see CheckPlan
+	Plan *Plan
+
+	// OnProgress is called for every step of the work if set, from several goroutines at once
+	OnProgress func(Progress)
+}
+
+// StoreRetention is how many versions of a single store are kept
+type StoreRetention struct {
+	KeepRecent uint64
+	KeepEvery  uint64
+}
+
+// Stage is the method a Progress comes from
+type Stage string
+
+const (
+	StageApp        Stage = "app"
+	StageTendermint Stage = "tendermint"
+	StageCompact    Stage = "compact"
+	StageAnalyze    Stage = "analyze"
+	StageChurn      Stage = "churn"
+	StageEstimate   Stage = "estimate"
+	StageCheck      Stage = "check"
+	StageRepair     Stage = "repair"
+)
+
+// Progress is a step of the work of a Pruner
+type Progress struct {
+	Stage Stage
+	// Name is the store or db the step is about
+	Name string
+	// Done and Total count the versions, heights or key ranges of Name when they are known
+	Done, Total int64
+	// Message describes the step
+	Message string
+}
+
+// SpaceError is returned when a filesystem has less free space than the dbs that can be compacted
+// into it at the same time, as a compaction can temporarily need as much space again
+type SpaceError struct {
+	Paths []string
+	Dir   string
+	// Size is the size of the dbs of Paths together
+	Size int64
+	Free int64
+}
+
+func (e *SpaceError) Error() string {
+	if len(e.Paths) == 1 {
+		return fmt.Sprintf("not enough free space to compact %s: the db is %s but only %s is free in %s",
+			e.Paths[0], dbutil.FormatBytes(e.Size), dbutil.FormatBytes(e.Free), e.Dir)
+	}
+	return fmt.Sprintf("not enough free space to compact %s at once: the dbs are %s but only %s is free in %s",
+		strings.Join(e.Paths, ", "), dbutil.FormatBytes(e.Size), dbutil.FormatBytes(e.Free), e.Dir)
+}
+
+// Pruner prunes and compacts the data of a node
+type Pruner struct {
+	opts Options
+
+	mtx sync.RWMutex
+	// upgrades and snapshots are the completed upgrade plans and the local snapshots once read
+	upgrades  []Upgrade
+	snapshots []Snapshot
+}
+
+// New returns a Pruner for the given options, or an error if they are invalid
+func New(opts Options) (*Pruner, error) {
+	if opts.Home == "" {
+		return nil, errors.New("home directory is required")
+	}
+	if opts.DataDir == "" {
+		opts.DataDir = "data"
+	}
+	if opts.App == nil {
+		a, err := LookupAppProfile(DefaultApp)
+		if err != nil {
+			return nil, err
+		}
+		opts.App = a
+	}
+	if opts.Engine == "" {
+		opts.Engine = EngineIAVL
+	}
+	if opts.Engine != EngineIAVL && opts.Engine != EngineOrphanSweep {
+		return nil, fmt.Errorf("invalid engine %q, must be %s or %s", opts.Engine, EngineIAVL, EngineOrphanSweep)
+	}
+	if opts.Parallel <= 0 {
+		opts.Parallel = 1
+	}
+	if opts.StoreWorkers <= 0 {
+		opts.StoreWorkers = 1
+	}
+	if opts.StoreWorkers > 1 && opts.Engine != EngineOrphanSweep {
+		return nil, fmt.Errorf("store workers require the %s engine", EngineOrphanSweep)
+	}
+	if opts.IAVLCacheSize <= 0 {
+		opts.IAVLCacheSize = DefaultIAVLCacheSize
+	}
+	opts.PruneHeights = mergeHeightRanges(opts.PruneHeights)
+	opts.KeepHeights = mergeHeightRanges(opts.KeepHeights)
+
+	return &Pruner{opts: opts}, nil
+}
+
+// Options returns the options of the pruner, with the defaults applied
+func (p *Pruner) Options() Options {
+	return p.opts
+}
+
+// StoreNames returns the sorted names of the stores of the application state to be pruned
+func (p *Pruner) StoreNames() []string {
+	names := make([]string, 0)
+	for name := range p.storeKeys() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// PruneHeights returns the sorted versions of a store to be deleted, keeping the latest KeepRecent
+// versions, every KeepEvery-th version, the protected heights of the app, KeepHeights, the upgrade
+// heights read by Upgrades and the snapshot heights read by Snapshots or given by
+// SnapshotInterval. The versions within PruneHeights are deleted regardless of KeepRecent and
+// KeepEvery.
+func (p *Pruner) PruneHeights(store string, versions []int64) []int64 {
+	heights := make([]int64, 0)
+	if len(versions) == 0 {
+		return heights
+	}
+
+	r, ok := p.opts.Stores[store]
+	if !ok {
+		r = StoreRetention{KeepRecent: p.opts.KeepRecent, KeepEvery: p.opts.KeepEvery}
+	}
+
+	protected := make(map[int64]bool)
+	for _, h := range p.opts.App.ProtectedHeights() {
+		protected[h] = true
+	}
+
+	upgrades := p.upgradeHeights()
+	snapshots := p.snapshotHeights()
+	latest := versions[len(versions)-1]
+	for _, v := range versions {
+		if protected[v] || inHeightRanges(p.opts.KeepHeights, v) || inHeightRanges(upgrades, v) ||
+			snapshots[v] || p.snapshotVersion(v, latest) {
+			continue
+		}
+		if inHeightRanges(p.opts.PruneHeights, v) && v != latest {
+			heights = append(heights, v)
+		} else if (r.KeepEvery == 0 || v%int64(r.KeepEvery) != 0) && v <= latest-int64(r.KeepRecent) {
+			heights = append(heights, v)
+		}
+	}
+
+	return heights
+}
+
+// storeKeys returns the keys of the stores to be pruned for the app and extra modules, or those of
+// the stores of the plan
+func (p *Pruner) storeKeys() map[string]*types.KVStoreKey {
+	if p.opts.Plan != nil {
+		names := make([]string, 0, len(p.opts.Plan.Stores))
+		for _, s := range p.opts.Plan.Stores {
+			names = append(names, s.Name)
+		}
+		return types.NewKVStoreKeys(names...)
+	}
+
+	keys := types.NewKVStoreKeys(p.opts.App.StoreKeys()...)
+
+	extraKeys := types.NewKVStoreKeys(p.opts.Modules...)
+
+	for key, value := range extraKeys {
+		keys[key] = value
+	}
+
+	return keys
+}
+
+// dbDir returns the directory of the databases
+func (p *Pruner) dbDir() string {
+	if filepath.IsAbs(p.opts.DataDir) {
+		return p.opts.DataDir
+	}
+	return filepath.Join(p.opts.Home, p.opts.DataDir)
+}
+
+// progress reports a step with a message formatted like fmt.Sprintf
+func (p *Pruner) progress(stage Stage, name string, done, total int64, format string, args ...interface{}) {
+	if p.opts.OnProgress == nil {
+		return
+	}
+
+	p.opts.OnProgress(Progress{
+		Stage:   stage,
+		Name:    name,
+		Done:    done,
+		Total:   total,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// checkPruneHeights refuses PruneHeights that include the latest version of the application state
+func (p *Pruner) checkPruneHeights(latest int64) error {
+	if inHeightRanges(p.opts.PruneHeights, latest) {
+		return fmt.Errorf("prune heights include the latest version %d, which is never pruned", latest)
+	}
+
+	return nil
+}
+
+// rewrite is a db at path compacted or rewritten into dir
+type rewrite struct {
+	path string
+	dir  string
+}
+
+// checkFreeSpace refuses the rewrites, up to parallel at once, if the free space on the filesystem
+// of their dirs is smaller than the largest parallel dbs rewritten into it together
+func checkFreeSpace(parallel int, rewrites ...rewrite) error {
+	type filesystem struct {
+		dir   string
+		paths []string
+		sizes map[string]int64
+	}
+
+	filesystems := make(map[string]*filesystem)
+	ids := make([]string, 0)
+	for _, r := range rewrites {
+		size, err := dbutil.DirSize(r.path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		id, err := dbutil.Filesystem(r.dir)
+		if err != nil {
+			return err
+		}
+		fs, ok := filesystems[id]
+		if !ok {
+			fs = &filesystem{dir: r.dir, sizes: make(map[string]int64)}
+			filesystems[id] = fs
+			ids = append(ids, id)
+		}
+		fs.paths = append(fs.paths, r.path)
+		fs.sizes[r.path] = size
+	}
+
+	for _, id := range ids {
+		fs := filesystems[id]
+		sort.SliceStable(fs.paths, func(i, j int) bool { return fs.sizes[fs.paths[i]] > fs.sizes[fs.paths[j]] })
+		if len(fs.paths) > parallel {
+			fs.paths = fs.paths[:parallel]
+		}
+
+		var size int64
+		for _, path := range fs.paths {
+			size += fs.sizes[path]
+		}
+
+		free, err := dbutil.FreeSpace(fs.dir)
+		if err != nil {
+			return err
+		}
+		if free < size {
+			return &SpaceError{Paths: fs.paths, Dir: fs.dir, Size: size, Free: free}
+		}
+	}
+
+	return nil
+}
+
+// StopReason describes why work stopped with the done ctx: out of time or interrupted
+func StopReason(ctx context.Context) string {
+	if ctx.Err() == context.DeadlineExceeded {
+		return "out of time"
+	}
+	return "interrupted"
+}
@@ -0,0 +1,72 @@
+package pruner
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	p, err := New(Options{Home: "/node"})
+	require.NoError(t, err)
+	require.Equal(t, EngineIAVL, p.Options().Engine)
+	require.Equal(t, DefaultIAVLCacheSize, p.Options().IAVLCacheSize)
+	require.Equal(t, "/node/data", p.dbDir())
+
+	_, err = New(Options{})
+	require.Error(t, err)
+	_, err = New(Options{Home: "/node", Engine: "sweep"})
+	require.Error(t, err)
+	_, err = New(Options{Home: "/node", StoreWorkers: 4})
+	require.Error(t, err)
+	_, err = New(Options{Home: "/node", Engine: EngineOrphanSweep, StoreWorkers: 4})
+	require.NoError(t, err)
+}
+
+func TestPruneHeights(t *testing.T) {
+	versions := []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+
+	p, err := New(Options{Home: "/node", KeepRecent: 3, KeepEvery: 4})
+	require.NoError(t, err)
+	require.Equal(t, []int64{1, 2, 3, 5, 6, 7}, p.PruneHeights("acc", versions))
+
+	p, err = New(Options{Home: "/node", KeepRecent: 0})
+	require.NoError(t, err)
+	require.Equal(t, versions, p.PruneHeights("acc", versions))
+	require.Empty(t, p.PruneHeights("acc", nil))
+
+	app := NewAppProfile("chain", SDKStoreKeys, Retention{}, []int64{2, 6})
+	p, err = New(Options{Home: "/node", App: app, KeepRecent: 3, KeepEvery: 4})
+	require.NoError(t, err)
+	require.Equal(t, []int64{1, 3, 5, 7}, p.PruneHeights("acc", versions))
+
+	p, err = New(Options{Home: "/node", KeepRecent: 3, Stores: map[string]StoreRetention{"oracle": {KeepRecent: 8}}})
+	require.NoError(t, err)
+	require.Equal(t, []int64{1, 2, 3, 4, 5, 6, 7}, p.PruneHeights("acc", versions))
+	require.Equal(t, []int64{1, 2}, p.PruneHeights("oracle", versions))
+
+	p, err = New(Options{
+		Home:         "/node",
+		App:          app,
+		KeepRecent:   3,
+		KeepEvery:    4,
+		PruneHeights: []HeightRange{{6, 10}},
+		KeepHeights:  []HeightRange{{1, 1}, {5, 5}, {8, 8}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []int64{3, 7, 9}, p.PruneHeights("acc", versions))
+	require.EqualError(t, p.checkPruneHeights(10), "prune heights include the latest version 10, which is never pruned")
+	require.NoError(t, p.checkPruneHeights(11))
+}
+
+func TestStoreNames(t *testing.T) {
+	bandchain, err := LookupAppProfile("bandchain")
+	require.NoError(t, err)
+	p, err := New(Options{Home: "/node", App: bandchain, Modules: []string{"wasm"}})
+	require.NoError(t, err)
+
+	names := p.StoreNames()
+	require.Contains(t, names, "oracle")
+	require.Contains(t, names, "wasm")
+	require.IsIncreasing(t, names)
+}
@@ -0,0 +1,225 @@
+package pruner
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/neilotoole/errgroup"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	db "github.com/tendermint/tm-db"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/iavldb"
+	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+)
+
+// StoreRepair is what was found and removed from a store
+type StoreRepair struct {
+	Name string
+	// Versions are the versions left, Broken the versions missing nodes that were deleted
+	Versions []int64
+	Broken   []int64
+	Nodes    int
+	Orphans  int
+}
+
+// RepairResult is the outcome of Repair
+type RepairResult struct {
+	// Stores are the stores repaired before the context was done, sorted by name, out of
+	// StoreCount stores
+	Stores     []StoreRepair
+	StoreCount int
+	// PruningHeights is the amount of heights to be pruned by the node, Gone the amount of them
+	// dropped since no store has them anymore
+	PruningHeights int
+	Gone           int
+}
+
+// Repair deletes the versions of every store whose tree is missing nodes and the orphan records
+// no existing version refers to, up to Parallel stores at once, then drops the heights no store
+// has anymore from the heights to be pruned by the node. With dryRun nothing is written, and the
+// nodes and orphans removed with the broken versions are estimated. It stops between stores once
+// ctx is done, in which case the pruning heights are left as they are.
+func (p *Pruner) Repair(ctx context.Context, dryRun bool) (*RepairResult, error) {
+	o := opt.Options{
+		DisableSeeksCompaction: true,
+		ReadOnly:               dryRun,
+	}
+
+	appDB, err := db.NewGoLevelDBWithOpts("application", p.dbDir(), &o)
+	if err != nil {
+		return nil, err
+	}
+	defer appDB.Close()
+
+	names, err := rootmulti.GetStoreNames(appDB)
+	if err != nil {
+		return nil, err
+	}
+
+	var mtx sync.Mutex
+	result := &RepairResult{Stores: make([]StoreRepair, 0, len(names)), StoreCount: len(names)}
+
+	// every store is queued at once, workers are only started for a queue that is not empty
+	errs, _ := errgroup.WithContextN(ctx, p.opts.Parallel, len(names))
+	for _, name := range names {
+		name := name
+		errs.Go(func() error {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			repair, err := p.repairStore(appDB, name, dryRun)
+			if err != nil {
+				return fmt.Errorf("failed to repair store %s: %w", name, err)
+			}
+
+			mtx.Lock()
+			result.Stores = append(result.Stores, repair)
+			mtx.Unlock()
+
+			return nil
+		})
+	}
+	err = errs.Wait()
+
+	sort.Slice(result.Stores, func(i, j int) bool {
+		return result.Stores[i].Name < result.Stores[j].Name
+	})
+	if err != nil {
+		return result, err
+	}
+
+	// the pruning heights depend on the versions of every store
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+
+	return result, repairPruningHeights(appDB, result, dryRun)
+}
+
+// repairStore deletes the versions of a store whose tree is missing nodes, then removes the
+// orphan records that no existing version refers to
+func (p *Pruner) repairStore(appDB db.DB, name string, dryRun bool) (StoreRepair, error) {
+	storeDB := db.NewPrefixDB(appDB, []byte("s/k:"+name+"/"))
+
+	versions, roots, err := iavldb.Roots(storeDB)
+	if err != nil {
+		return StoreRepair{}, err
+	}
+
+	repair := StoreRepair{Name: name, Broken: make([]int64, 0)}
+
+	p.progress(StageRepair, name, 0, int64(len(versions)), "checking store: %s (%d versions)", name, len(versions))
+	checker := iavldb.NewChecker(storeDB)
+	kept := make([]int64, 0, len(versions))
+	for _, version := range versions {
+		problems, err := checker.CheckTree(version, roots[version])
+		if err != nil {
+			return repair, err
+		}
+		if len(problems) == 0 {
+			kept = append(kept, version)
+		} else {
+			repair.Broken = append(repair.Broken, version)
+		}
+	}
+	repair.Versions = kept
+
+	if len(repair.Broken) > 0 && repair.Broken[len(repair.Broken)-1] == versions[len(versions)-1] {
+		return repair, fmt.Errorf("latest version %d is broken and cannot be deleted, restore the db from a snapshot",
+			versions[len(versions)-1])
+	}
+
+	batch := storeDB.NewBatch()
+	defer batch.Close()
+
+	for _, version := range repair.Broken {
+		predecessor := int64(0)
+		if i := sort.Search(len(kept), func(i int) bool { return kept[i] >= version }); i > 0 {
+			predecessor = kept[i-1]
+		}
+
+		n, err := iavldb.DeleteVersion(storeDB, batch, version, predecessor)
+		if err != nil {
+			return repair, err
+		}
+		repair.Nodes += n
+	}
+
+	if !dryRun {
+		if err := batch.WriteSync(); err != nil {
+			return repair, err
+		}
+	}
+
+	// orphans moved by the deletes above are checked as well
+	problems, err := iavldb.CheckOrphans(storeDB, kept)
+	if err != nil {
+		return repair, err
+	}
+
+	orphanBatch := storeDB.NewBatch()
+	defer orphanBatch.Close()
+
+	for _, problem := range problems {
+		if problem.Kind != iavldb.ProblemDanglingOrphan {
+			continue
+		}
+
+		if err := orphanBatch.Delete(problem.Key); err != nil {
+			return repair, err
+		}
+		// a node that is not part of any kept tree is not referred to anymore, as all of them
+		// were walked above
+		if _, _, hash := iavldb.ParseOrphanKey(problem.Key); !checker.Verified(hash) {
+			if err := orphanBatch.Delete(iavldb.NodeKey(hash)); err != nil {
+				return repair, err
+			}
+			repair.Nodes++
+		}
+		repair.Orphans++
+	}
+
+	if dryRun {
+		return repair, nil
+	}
+
+	return repair, orphanBatch.WriteSync()
+}
+
+// repairPruningHeights drops the heights that no store has anymore from the heights to be pruned
+// on the next run, since the node would fail deleting them again
+func repairPruningHeights(appDB db.DB, result *RepairResult, dryRun bool) error {
+	heights, err := rootmulti.GetPruningHeights(appDB)
+	if err != nil {
+		return err
+	}
+	result.PruningHeights = len(heights)
+	if len(heights) == 0 {
+		// nothing is left to be pruned
+		return nil
+	}
+
+	existing := make(map[int64]bool)
+	for _, r := range result.Stores {
+		for _, v := range r.Versions {
+			existing[v] = true
+		}
+	}
+
+	kept := make([]int64, 0, len(heights))
+	for _, h := range heights {
+		if existing[h] {
+			kept = append(kept, h)
+		}
+	}
+
+	result.Gone = len(heights) - len(kept)
+	if dryRun || len(kept) == len(heights) {
+		return nil
+	}
+
+	return rootmulti.SetPruningHeights(appDB, kept)
+}
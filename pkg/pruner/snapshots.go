@@ -0,0 +1,343 @@
+package pruner
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/cosmos/cosmos-sdk/snapshots"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	db "github.com/tendermint/tm-db"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/dbutil"
+)
+
+// Snapshot is a local state-sync snapshot of the application state
+type Snapshot struct {
+	Height int64
+	Format uint32
+	Chunks uint32
+	// Hash is the SHA-256 hash of all chunks, ChunkHashes those of every chunk
+	Hash        []byte
+	ChunkHashes [][]byte
+	// Size is the size of the chunk files on disk
+	Size int64
+}
+
+// SnapshotPruneResult is the outcome of PruneSnapshots
+type SnapshotPruneResult struct {
+	Kept    []Snapshot
+	Deleted []Snapshot
+	// Orphaned are the chunk directories without a snapshot in the metadata db, deleted as well
+	Orphaned []string
+}
+
+// snapshotDir returns the directory of the local snapshot store
+func (p *Pruner) snapshotDir() string {
+	return filepath.Join(p.dbDir(), "snapshots")
+}
+
+// SnapshotPath returns the directory of the chunks of a snapshot
+func (p *Pruner) SnapshotPath(s Snapshot) string {
+	return filepath.Join(p.snapshotDir(), strconv.FormatInt(s.Height, 10), strconv.FormatUint(uint64(s.Format), 10))
+}
+
+// Snapshots reads the local state-sync snapshots from the snapshot store, sorted by height, none if
+// there is no snapshot store. With ProtectSnapshots set, the pruner keeps their versions from then
+// on. PruneApp, Plan and Status read them by themselves.
+func (p *Pruner) Snapshots(ctx context.Context) ([]Snapshot, error) {
+	var list []Snapshot
+	err := p.withSnapshotStore(true, func(store *snapshots.Store) (err error) {
+		list, err = p.listSnapshots(store)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.setSnapshots(list)
+
+	return list, nil
+}
+
+// OrphanedSnapshots returns the chunk directories of the snapshot store without a snapshot in the
+// metadata db, which snapshots interrupted or deleted halfway leave behind
+func (p *Pruner) OrphanedSnapshots(ctx context.Context) ([]string, error) {
+	list, err := p.Snapshots(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.orphanedSnapshotDirs(list)
+}
+
+// PruneSnapshots deletes the snapshots of all but the latest keep heights from the snapshot store
+// along with their chunks, as the node does after taking a snapshot, and the orphaned chunk
+// directories. With dryRun set, it only returns what would be deleted.
+func (p *Pruner) PruneSnapshots(ctx context.Context, keep int, dryRun bool) (*SnapshotPruneResult, error) {
+	var result *SnapshotPruneResult
+	err := p.withSnapshotStore(dryRun, func(store *snapshots.Store) error {
+		list, err := p.listSnapshots(store)
+		if err != nil {
+			return err
+		}
+		orphaned, err := p.orphanedSnapshotDirs(list)
+		if err != nil {
+			return err
+		}
+
+		result = &SnapshotPruneResult{Kept: list, Deleted: make([]Snapshot, 0), Orphaned: make([]string, 0)}
+		heights := 0
+		for i := len(list) - 1; i >= 0; i-- {
+			if i == len(list)-1 || list[i].Height != list[i+1].Height {
+				heights++
+			}
+			if heights > keep {
+				result.Kept, result.Deleted = list[i+1:], list[:i+1]
+				break
+			}
+		}
+		if dryRun {
+			result.Orphaned = orphaned
+			return nil
+		}
+
+		deleted := result.Deleted
+		result.Deleted = make([]Snapshot, 0, len(deleted))
+		for i, s := range deleted {
+			if ctx.Err() != nil {
+				// the snapshots not deleted yet are still kept
+				result.Kept = append(append([]Snapshot{}, deleted[i:]...), result.Kept...)
+				return nil
+			}
+			if err := store.Delete(uint64(s.Height), s.Format); err != nil {
+				return fmt.Errorf("failed to delete snapshot at height %d format %d: %w", s.Height, s.Format, err)
+			}
+			if err := removeEmptyDir(filepath.Dir(p.SnapshotPath(s))); err != nil {
+				return err
+			}
+			result.Deleted = append(result.Deleted, s)
+		}
+		for _, dir := range orphaned {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err := os.RemoveAll(dir); err != nil {
+				return err
+			}
+			if err := removeEmptyDir(filepath.Dir(dir)); err != nil {
+				return err
+			}
+			result.Orphaned = append(result.Orphaned, dir)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	p.setSnapshots(result.Kept)
+
+	return result, ctx.Err()
+}
+
+// withSnapshotStore calls fn with the snapshot store, if there is a metadata db
+func (p *Pruner) withSnapshotStore(readOnly bool, fn func(store *snapshots.Store) error) error {
+	dir := p.snapshotDir()
+	if _, err := os.Stat(filepath.Join(dir, "metadata.db")); os.IsNotExist(err) {
+		return fn(nil)
+	}
+
+	o := opt.Options{
+		DisableSeeksCompaction: true,
+		ReadOnly:               readOnly,
+	}
+
+	metadataDB, err := db.NewGoLevelDBWithOpts("metadata", dir, &o)
+	if err != nil {
+		return err
+	}
+	defer metadataDB.Close()
+
+	store, err := snapshots.NewStore(metadataDB, dir)
+	if err != nil {
+		return err
+	}
+
+	return fn(store)
+}
+
+// listSnapshots reads the snapshots of store sorted by height, none without a store
+func (p *Pruner) listSnapshots(store *snapshots.Store) ([]Snapshot, error) {
+	list := make([]Snapshot, 0)
+	if store == nil {
+		return list, nil
+	}
+
+	stored, err := store.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshots: %w", err)
+	}
+
+	for _, s := range stored {
+		snapshot := Snapshot{
+			Height:      int64(s.Height),
+			Format:      s.Format,
+			Chunks:      s.Chunks,
+			Hash:        s.Hash,
+			ChunkHashes: s.Metadata.ChunkHashes,
+		}
+		if snapshot.Size, err = dbutil.DirSize(p.SnapshotPath(snapshot)); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		list = append(list, snapshot)
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].Height != list[j].Height {
+			return list[i].Height < list[j].Height
+		}
+		return list[i].Format < list[j].Format
+	})
+
+	return list, nil
+}
+
+// orphanedSnapshotDirs returns the <height>/<format> directories of the snapshot store that are
+// not one of the snapshots in list, and the <height> directories without any format
+func (p *Pruner) orphanedSnapshotDirs(list []Snapshot) ([]string, error) {
+	orphaned := make([]string, 0)
+
+	known := make(map[string]bool, len(list))
+	for _, s := range list {
+		known[p.SnapshotPath(s)] = true
+	}
+
+	dir := p.snapshotDir()
+	heights, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return orphaned, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, height := range heights {
+		if _, err := strconv.ParseUint(height.Name(), 10, 64); err != nil || !height.IsDir() {
+			continue
+		}
+
+		heightDir := filepath.Join(dir, height.Name())
+		formats, err := os.ReadDir(heightDir)
+		if err != nil {
+			return nil, err
+		}
+		if len(formats) == 0 {
+			orphaned = append(orphaned, heightDir)
+			continue
+		}
+		for _, format := range formats {
+			if path := filepath.Join(heightDir, format.Name()); !known[path] {
+				orphaned = append(orphaned, path)
+			}
+		}
+	}
+
+	return orphaned, nil
+}
+
+// removeEmptyDir removes dir if it exists and is empty
+func removeEmptyDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) || (err == nil && len(entries) > 0) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(dir)
+}
+
+// CheckSnapshot compares the chunk files of a snapshot to the chunk hashes and the hash in its
+// metadata, and returns the first difference
+func (p *Pruner) CheckSnapshot(ctx context.Context, s Snapshot) error {
+	if len(s.ChunkHashes) != int(s.Chunks) {
+		return fmt.Errorf("snapshot has %d chunks, but %d chunk hashes", s.Chunks, len(s.ChunkHashes))
+	}
+
+	snapshotHasher := sha256.New()
+	chunkHasher := sha256.New()
+	for i := uint32(0); i < s.Chunks; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		chunkHasher.Reset()
+		if err := hashFile(filepath.Join(p.SnapshotPath(s), strconv.FormatUint(uint64(i), 10)),
+			io.MultiWriter(chunkHasher, snapshotHasher)); err != nil {
+			return fmt.Errorf("failed to read chunk %d: %w", i, err)
+		}
+		if hash := chunkHasher.Sum(nil); !bytes.Equal(hash, s.ChunkHashes[i]) {
+			return fmt.Errorf("chunk %d has hash %X, expected %X", i, hash, s.ChunkHashes[i])
+		}
+	}
+	if hash := snapshotHasher.Sum(nil); !bytes.Equal(hash, s.Hash) {
+		return fmt.Errorf("snapshot has hash %X, expected %X", hash, s.Hash)
+	}
+
+	return nil
+}
+
+// hashFile writes the content of the file at path to hasher
+func hashFile(path string, hasher io.Writer) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(hasher, file)
+	return err
+}
+
+// setSnapshots records the snapshots read
+func (p *Pruner) setSnapshots(list []Snapshot) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.snapshots = list
+}
+
+// snapshotHeights returns the heights of the recorded snapshots, none unless ProtectSnapshots is set
+func (p *Pruner) snapshotHeights() map[int64]bool {
+	heights := make(map[int64]bool)
+	if !p.opts.ProtectSnapshots {
+		return heights
+	}
+
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	for _, s := range p.snapshots {
+		heights[s.Height] = true
+	}
+
+	return heights
+}
+
+// snapshotVersion returns whether the version is one of the latest SnapshotKeepRecent multiples of
+// SnapshotInterval up to the latest version, which the node keeps snapshots of
+func (p *Pruner) snapshotVersion(version, latest int64) bool {
+	interval := int64(p.opts.SnapshotInterval)
+	if !p.opts.ProtectSnapshots || interval == 0 || version%interval != 0 {
+		return false
+	}
+
+	keepRecent := int64(p.opts.SnapshotKeepRecent)
+	return keepRecent == 0 || version > (latest/interval-keepRecent)*interval
+}
@@ -0,0 +1,119 @@
+package pruner
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cosmos/cosmos-sdk/snapshots"
+	"github.com/stretchr/testify/require"
+	db "github.com/tendermint/tm-db"
+)
+
+func TestSnapshots(t *testing.T) {
+	home := t.TempDir()
+	p, err := New(Options{Home: home, ProtectSnapshots: true})
+	require.NoError(t, err)
+
+	list, err := p.Snapshots(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, list)
+
+	dir := filepath.Join(home, "data", "snapshots")
+	metadataDB, err := db.NewGoLevelDB("metadata", dir)
+	require.NoError(t, err)
+	store, err := snapshots.NewStore(metadataDB, dir)
+	require.NoError(t, err)
+	for _, height := range []uint64{8, 4} {
+		chunks := make(chan io.ReadCloser, 1)
+		chunks <- io.NopCloser(bytes.NewReader([]byte("chunk")))
+		close(chunks)
+		_, err := store.Save(height, 1, chunks)
+		require.NoError(t, err)
+	}
+	require.NoError(t, metadataDB.Close())
+
+	list, err = p.Snapshots(context.Background())
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	require.Equal(t, int64(4), list[0].Height)
+	require.Equal(t, uint32(1), list[0].Chunks)
+	require.Equal(t, []int64{1, 2, 3, 5, 6, 7, 9, 10}, p.PruneHeights("acc", []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}))
+}
+
+func TestPruneSnapshots(t *testing.T) {
+	home := t.TempDir()
+	p, err := New(Options{Home: home})
+	require.NoError(t, err)
+
+	dir := filepath.Join(home, "data", "snapshots")
+	metadataDB, err := db.NewGoLevelDB("metadata", dir)
+	require.NoError(t, err)
+	store, err := snapshots.NewStore(metadataDB, dir)
+	require.NoError(t, err)
+	for _, s := range []struct {
+		height uint64
+		format uint32
+	}{{4, 1}, {8, 1}, {12, 1}, {12, 2}} {
+		chunks := make(chan io.ReadCloser, 2)
+		chunks <- io.NopCloser(bytes.NewReader([]byte("chunk")))
+		chunks <- io.NopCloser(bytes.NewReader([]byte("another chunk")))
+		close(chunks)
+		_, err := store.Save(s.height, s.format, chunks)
+		require.NoError(t, err)
+	}
+	require.NoError(t, metadataDB.Close())
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "6", "1"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "10"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "8", "1", "1"), []byte("corrupt chunk"), 0644))
+
+	orphaned, err := p.OrphanedSnapshots(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(dir, "10"), filepath.Join(dir, "6", "1")}, orphaned)
+
+	result, err := p.PruneSnapshots(context.Background(), 2, true)
+	require.NoError(t, err)
+	require.Len(t, result.Deleted, 1)
+	require.Len(t, result.Kept, 3)
+	require.Len(t, result.Orphaned, 2)
+	require.DirExists(t, filepath.Join(dir, "4", "1"))
+
+	result, err = p.PruneSnapshots(context.Background(), 2, false)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), result.Deleted[0].Height)
+	require.Len(t, result.Orphaned, 2)
+	require.NoDirExists(t, filepath.Join(dir, "4"))
+	require.NoDirExists(t, filepath.Join(dir, "6"))
+	require.NoDirExists(t, filepath.Join(dir, "10"))
+
+	list, err := p.Snapshots(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, result.Kept, list)
+	require.Equal(t, int64(8), list[0].Height)
+	require.Equal(t, int64(18), list[1].Size)
+
+	require.Error(t, p.CheckSnapshot(context.Background(), list[0]))
+	for _, s := range list[1:] {
+		require.NoError(t, p.CheckSnapshot(context.Background(), s))
+	}
+}
+
+func TestSnapshotVersion(t *testing.T) {
+	versions := []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}
+
+	p, err := New(Options{Home: "/node", ProtectSnapshots: true, SnapshotInterval: 3, SnapshotKeepRecent: 2})
+	require.NoError(t, err)
+	require.Equal(t, []int64{1, 2, 3, 4, 5, 7, 8, 10, 11}, p.PruneHeights("acc", versions))
+
+	p, err = New(Options{Home: "/node", ProtectSnapshots: true, SnapshotInterval: 3})
+	require.NoError(t, err)
+	require.Equal(t, []int64{1, 2, 4, 5, 7, 8, 10, 11}, p.PruneHeights("acc", versions))
+
+	p, err = New(Options{Home: "/node", SnapshotInterval: 3})
+	require.NoError(t, err)
+	require.Equal(t, versions, p.PruneHeights("acc", versions))
+}
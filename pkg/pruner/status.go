@@ -0,0 +1,138 @@
+package pruner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	tmstore "github.com/tendermint/tendermint/store"
+	db "github.com/tendermint/tm-db"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/dbutil"
+	"github.com/binaryholdings/cosmos-pruner/internal/iavldb"
+	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+)
+
+// Status is how much of the data of a node there is to prune
+type Status struct {
+	// LatestVersion is the latest version of the application state
+	LatestVersion int64
+	// Stores are the stores of the application state, sorted by name
+	Stores []StoreStatus
+	// BlockBase and BlockHeight are the first and last blocks in the block store, PruneHeight is
+	// the height PruneTendermint would prune them below (0=none)
+	BlockBase   int64
+	BlockHeight int64
+	PruneHeight int64
+	// DBs are the dbs that exist, in the order Compact compacts them
+	DBs []DBStatus
+}
+
+// StoreStatus is the versions of a single store
+type StoreStatus struct {
+	Name string
+	// Versions is the amount of versions of the store, from First up to Latest
+	Versions int
+	First    int64
+	Latest   int64
+	// ToPrune is the amount of versions PruneApp would delete, 0 if it does not prune the store
+	ToPrune int
+}
+
+// DBStatus is the size on disk of a db
+type DBStatus struct {
+	Name string
+	Path string
+	Size int64
+}
+
+// Status reads the versions of the application state if CosmosSDK is set, and the blocks of
+// tendermint if Tendermint is set, without modifying them.
+func (p *Pruner) Status(ctx context.Context) (*Status, error) {
+	dbDir := p.dbDir()
+	status := &Status{}
+
+	if p.opts.ProtectUpgrades {
+		if _, err := p.Upgrades(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if p.opts.ProtectSnapshots && p.opts.CosmosSDK {
+		if _, err := p.Snapshots(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	o := opt.Options{
+		DisableSeeksCompaction: true,
+		ReadOnly:               true,
+	}
+
+	if p.opts.CosmosSDK {
+		appDB, err := db.NewGoLevelDBWithOpts("application", dbDir, &o)
+		if err != nil {
+			return nil, err
+		}
+		defer appDB.Close()
+
+		if status.LatestVersion, err = rootmulti.GetLatestVersion(appDB); err != nil {
+			return nil, err
+		}
+		names, err := rootmulti.GetStoreNames(appDB)
+		if err != nil {
+			return nil, err
+		}
+
+		keys := p.storeKeys()
+		for _, name := range names {
+			if err := ctx.Err(); err != nil {
+				return status, err
+			}
+
+			versions, _, err := iavldb.Roots(db.NewPrefixDB(appDB, []byte("s/k:"+name+"/")))
+			if err != nil {
+				return nil, err
+			}
+
+			s := StoreStatus{Name: name, Versions: len(versions)}
+			if len(versions) > 0 {
+				s.First, s.Latest = versions[0], versions[len(versions)-1]
+			}
+			if _, ok := keys[name]; ok {
+				s.ToPrune = len(p.PruneHeights(name, versions))
+			}
+			status.Stores = append(status.Stores, s)
+		}
+	}
+
+	if p.opts.Tendermint {
+		if _, err := os.Stat(filepath.Join(dbDir, "blockstore.db")); err == nil {
+			blockStoreDB, err := db.NewGoLevelDBWithOpts("blockstore", dbDir, &o)
+			if err != nil {
+				return nil, err
+			}
+			blockStore := tmstore.NewBlockStore(blockStoreDB)
+			defer blockStore.Close()
+
+			status.BlockBase, status.BlockHeight = blockStore.Base(), blockStore.Height()
+			pruneHeight := p.BlockPruneHeight(status.BlockBase, status.BlockHeight)
+			if p.opts.KeepBlocks > 0 && pruneHeight > status.BlockBase {
+				status.PruneHeight = pruneHeight
+			}
+		}
+	}
+
+	for _, target := range p.compactTargets() {
+		path := filepath.Join(target.dir, target.name+".db")
+		size, err := dbutil.DirSize(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		status.DBs = append(status.DBs, DBStatus{Name: target.name, Path: path, Size: size})
+	}
+
+	return status, nil
+}
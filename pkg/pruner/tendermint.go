@@ -0,0 +1,400 @@
+package pruner
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/neilotoole/errgroup"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	tmproto "github.com/tendermint/tendermint/proto/tendermint/types"
+	"github.com/tendermint/tendermint/state"
+	tmstore "github.com/tendermint/tendermint/store"
+	tmtypes "github.com/tendermint/tendermint/types"
+	db "github.com/tendermint/tm-db"
+)
+
+// TendermintResult is the outcome of PruneTendermint
+type TendermintResult struct {
+	// PruneHeight is the height the blocks and states are pruned below, 0 when all are kept
+	PruneHeight int64
+	// BlockHeight and StateHeight are the heights the block and state store were pruned up to,
+	// lower than PruneHeight when the context was done first
+	BlockHeight int64
+	StateHeight int64
+	// EvidencePending and EvidenceCommitted are the amounts of expired evidence deleted
+	EvidencePending   int
+	EvidenceCommitted int
+}
+
+// PruneTendermint deletes the tendermint blocks and states of BlockPruneRanges and the expired
+// evidence below BlockPruneHeight, or those of the plan, and compacts their dbs afterwards unless
+// ctx is done.
+func (p *Pruner) PruneTendermint(ctx context.Context) (*TendermintResult, error) {
+	dbDir := p.dbDir()
+
+	o := opt.Options{
+		DisableSeeksCompaction: true,
+	}
+
+	// Get BlockStore
+	blockStoreDB, err := db.NewGoLevelDBWithOpts("blockstore", dbDir, &o)
+	if err != nil {
+		return nil, err
+	}
+	blockStore := tmstore.NewBlockStore(blockStoreDB)
+	defer blockStore.Close()
+
+	// Get StateStore
+	stateDB, err := db.NewGoLevelDBWithOpts("state", dbDir, &o)
+	if err != nil {
+		return nil, err
+	}
+	defer stateDB.Close()
+
+	stateStore := state.NewStore(stateDB)
+
+	// Get EvidenceDB
+	evidenceDB, err := db.NewGoLevelDBWithOpts("evidence", dbDir, &o)
+	if err != nil {
+		return nil, err
+	}
+	defer evidenceDB.Close()
+
+	base := blockStore.Base()
+	result := &TendermintResult{BlockHeight: base, StateHeight: base}
+
+	var pruneHeight int64
+	var ranges []HeightRange
+	if plan := p.opts.Plan; plan != nil {
+		if plan.Tendermint == nil {
+			return result, nil
+		}
+		pruneHeight, ranges = plan.Tendermint.PruneHeight, plan.Tendermint.Blocks
+	} else {
+		if p.opts.KeepBlocks == 0 {
+			return result, nil
+		}
+		if p.opts.KeepBlocks < MinKeepBlocks {
+			return nil, fmt.Errorf("Your min-retain-blocks %+v is lower than the minimum %d", p.opts.KeepBlocks, MinKeepBlocks)
+		}
+		if p.opts.ProtectUpgrades && !p.upgradesLoaded() {
+			if _, err := p.Upgrades(ctx); err != nil {
+				return nil, err
+			}
+		}
+		pruneHeight = p.BlockPruneHeight(base, blockStore.Height())
+		ranges = p.BlockPruneRanges(base, blockStore.Height())
+		for _, r := range p.upgradeHeights() {
+			if r.To >= base && r.From < pruneHeight {
+				p.progress(StageTendermint, "blockstore", 0, 0,
+					"keeping the blocks and states of the upgrade heights %s, the blocks around them are pruned", r)
+			}
+		}
+	}
+	result.PruneHeight = pruneHeight
+
+	// the evidence db is compacted first, then the block and state db at once
+	if err := checkFreeSpace(2,
+		rewrite{filepath.Join(dbDir, "blockstore.db"), dbDir},
+		rewrite{filepath.Join(dbDir, "state.db"), dbDir},
+		rewrite{filepath.Join(dbDir, "evidence.db"), dbDir},
+	); err != nil {
+		return nil, err
+	}
+
+	// evidence expiry needs the block times, so it must run before the blocks are pruned
+	p.progress(StageTendermint, "evidence", 0, 0, "pruning evidence store")
+	st, err := stateStore.Load()
+	if err != nil {
+		return nil, err
+	}
+	result.EvidencePending, result.EvidenceCommitted, err = pruneEvidence(ctx, evidenceDB, blockStore, st, pruneHeight)
+	if err != nil {
+		return result, err
+	}
+	if ctx.Err() != nil {
+		p.progress(StageTendermint, "evidence", 0, 0, "%s pruning evidence store: %d pending, %d committed deleted",
+			StopReason(ctx), result.EvidencePending, result.EvidenceCommitted)
+	} else {
+		p.progress(StageTendermint, "evidence", 0, 0, "pruned evidence store: %d pending, %d committed",
+			result.EvidencePending, result.EvidenceCommitted)
+	}
+
+	if err := p.compactTMStore(ctx, "evidence", "evidence", evidenceDB); err != nil {
+		return result, err
+	}
+
+	errs, _ := errgroup.WithContext(ctx)
+	errs.Go(func() error {
+		p.progress(StageTendermint, "blockstore", 0, pruneHeight-base, "pruning block store")
+		// prune block store
+		if len(ranges) > 0 {
+			height, err := pruneRanges(ctx, ranges, pruneHeight, blockPruneStep, func(from, to int64) error {
+				if to <= blockStore.Base() {
+					return nil
+				}
+				if from > blockStore.Base() {
+					// the blocks above a protected upgrade are deleted without moving the base
+					return deleteBlocks(blockStoreDB, blockStore, from, to)
+				}
+				_, err := blockStore.PruneBlocks(to)
+				return err
+			})
+			result.BlockHeight = height
+			if err != nil {
+				return err
+			}
+			p.progress(StageTendermint, "blockstore", height-base, pruneHeight-base,
+				"pruned block store up to height %d of %d", height, pruneHeight)
+		}
+
+		return p.compactTMStore(ctx, "block", "blockstore", blockStoreDB)
+	})
+
+	p.progress(StageTendermint, "state", 0, pruneHeight-base, "pruning state store")
+
+	// prune state store
+	if len(ranges) > 0 {
+		height, err := pruneRanges(ctx, ranges, pruneHeight, statePruneStep, stateStore.PruneStates)
+		result.StateHeight = height
+		if err != nil {
+			return result, err
+		}
+		p.progress(StageTendermint, "state", height-base, pruneHeight-base,
+			"pruned state store up to height %d of %d", height, pruneHeight)
+	}
+
+	if err := p.compactTMStore(ctx, "state", "state", stateDB); err != nil {
+		return result, err
+	}
+
+	if err := errs.Wait(); err != nil {
+		return result, err
+	}
+
+	return result, ctx.Err()
+}
+
+const (
+	// blockPruneStep and statePruneStep are the amount of heights pruned from the block and state
+	// store between checks whether pruning was stopped. Every step of the state store keeps the
+	// validator set its last height refers to, so its steps are larger.
+	blockPruneStep = 10000
+	statePruneStep = 100000
+)
+
+// pruneInSteps calls prune for the heights [from, to) up to height, oldest first, step heights at
+// a time until ctx is done. It returns the height pruned up to.
+func pruneInSteps(ctx context.Context, from, height, step int64, prune func(from, to int64) error) (int64, error) {
+	for from < height {
+		if ctx.Err() != nil {
+			return from, nil
+		}
+
+		to := from + step
+		if to > height {
+			to = height
+		}
+		if err := prune(from, to); err != nil {
+			return from, err
+		}
+		from = to
+	}
+
+	return from, nil
+}
+
+// pruneRanges calls pruneInSteps for every range of heights, oldest first, until ctx is done. It
+// returns the height pruned up to, which is height once all ranges below it are pruned.
+func pruneRanges(ctx context.Context, ranges []HeightRange, height, step int64, prune func(from, to int64) error) (int64, error) {
+	for _, r := range ranges {
+		to, err := pruneInSteps(ctx, r.From, r.To+1, step, prune)
+		if err != nil || to <= r.To {
+			return to, err
+		}
+	}
+
+	return height, nil
+}
+
+// deleteBlocks deletes the blocks [from, to) like BlockStore.PruneBlocks does, but without moving
+// the base of the block store, so that the blocks below them are kept
+func deleteBlocks(blockStoreDB db.DB, blockStore *tmstore.BlockStore, from, to int64) error {
+	batch := blockStoreDB.NewBatch()
+	defer batch.Close()
+
+	// keys of the block store, see tendermint/store/store.go
+	for h := from; h < to; h++ {
+		meta := blockStore.LoadBlockMeta(h)
+		if meta == nil {
+			// deleted already
+			continue
+		}
+
+		keys := [][]byte{
+			[]byte(fmt.Sprintf("H:%v", h)),
+			[]byte(fmt.Sprintf("BH:%x", meta.BlockID.Hash)),
+			[]byte(fmt.Sprintf("C:%v", h)),
+			[]byte(fmt.Sprintf("SC:%v", h)),
+		}
+		for part := 0; part < int(meta.BlockID.PartSetHeader.Total); part++ {
+			keys = append(keys, []byte(fmt.Sprintf("P:%v:%v", h, part)))
+		}
+		for _, key := range keys {
+			if err := batch.Delete(key); err != nil {
+				return err
+			}
+		}
+	}
+
+	return batch.WriteSync()
+}
+
+// compactTMStore compacts the tendermint db dbName, unless pruning was stopped or the plan does
+// not compact it
+func (p *Pruner) compactTMStore(ctx context.Context, name, dbName string, tmDB *db.GoLevelDB) error {
+	if !p.compacts(dbName) {
+		return nil
+	}
+	if ctx.Err() != nil {
+		p.progress(StageTendermint, name, 0, 0, "%s, skipping compaction of %s store", StopReason(ctx), name)
+		return nil
+	}
+
+	p.progress(StageTendermint, name, 0, 0, "compacting %s store", name)
+	return tmDB.ForceCompact(nil, nil)
+}
+
+const (
+	// prefixes used by the tendermint evidence pool, see tendermint/evidence/pool.go
+	evidenceKeyCommitted = byte(0x00)
+	evidenceKeyPending   = byte(0x01)
+)
+
+// evidencePruneStep is the amount of evidence keys read between the writes of the deleted ones
+// and the checks whether pruning was stopped
+const evidencePruneStep = 10000
+
+// pruneEvidence deletes the committed and pending evidence below pruneHeight that has also
+// expired according to the evidence consensus params. Like the evidence pool, evidence only
+// expires once it is older than both MaxAgeNumBlocks and MaxAgeDuration. The deleted keys are
+// written every evidencePruneStep keys until ctx is done, it returns the amounts deleted so far.
+func pruneEvidence(
+	ctx context.Context, evidenceDB db.DB, blockStore *tmstore.BlockStore, st state.State, pruneHeight int64,
+) (pending int, committed int, err error) {
+	params := st.ConsensusParams.Evidence
+
+	// blocks below the base have already been pruned, their time can be no later than the base time
+	var baseTime time.Time
+	if meta := blockStore.LoadBaseMeta(); meta != nil {
+		baseTime = meta.Header.Time
+	}
+
+	isExpired := func(height int64, evTime time.Time) bool {
+		return st.LastBlockHeight-height > params.MaxAgeNumBlocks &&
+			st.LastBlockTime.Sub(evTime) > params.MaxAgeDuration
+	}
+
+	// expiredStep returns the expired keys of the next evidencePruneStep keys from start, and the
+	// key to continue from, nil when there are no more keys below pruneHeight
+	expiredStep := func(prefix byte, start []byte) (expired [][]byte, next []byte, err error) {
+		itr, err := evidenceDB.Iterator(start, []byte{prefix + 1})
+		if err != nil {
+			return nil, nil, err
+		}
+		defer itr.Close()
+
+		for read := 0; itr.Valid(); itr.Next() {
+			if read == evidencePruneStep {
+				return expired, append([]byte{}, itr.Key()...), nil
+			}
+			read++
+
+			key := itr.Key()
+			height, err := evidenceHeight(key)
+			if err != nil {
+				return nil, nil, err
+			}
+			if height >= pruneHeight {
+				// keys are ordered by height
+				break
+			}
+
+			var evTime time.Time
+			if prefix == evidenceKeyPending {
+				var evpb tmproto.Evidence
+				if err := evpb.Unmarshal(itr.Value()); err != nil {
+					return nil, nil, err
+				}
+				ev, err := tmtypes.EvidenceFromProto(&evpb)
+				if err != nil {
+					return nil, nil, err
+				}
+				evTime = ev.Time()
+			} else if meta := blockStore.LoadBlockMeta(height); meta != nil {
+				evTime = meta.Header.Time
+			} else {
+				evTime = baseTime
+			}
+
+			if isExpired(height, evTime) {
+				expired = append(expired, append([]byte{}, key...))
+			}
+		}
+
+		return expired, nil, itr.Error()
+	}
+
+	for _, prefix := range []byte{evidenceKeyCommitted, evidenceKeyPending} {
+		for start := []byte{prefix}; start != nil; {
+			if ctx.Err() != nil {
+				return pending, committed, nil
+			}
+
+			// the keys are deleted once the iterator is closed, a memory db can not be written while
+			// it is read
+			expired, next, err := expiredStep(prefix, start)
+			if err != nil {
+				return pending, committed, err
+			}
+			if err := deleteKeys(evidenceDB, expired); err != nil {
+				return pending, committed, err
+			}
+
+			if prefix == evidenceKeyPending {
+				pending += len(expired)
+			} else {
+				committed += len(expired)
+			}
+			start = next
+		}
+	}
+
+	return pending, committed, nil
+}
+
+// deleteKeys deletes keys from tmDB in a single batch
+func deleteKeys(tmDB db.DB, keys [][]byte) error {
+	batch := tmDB.NewBatch()
+	defer batch.Close()
+
+	for _, key := range keys {
+		if err := batch.Delete(key); err != nil {
+			return err
+		}
+	}
+
+	return batch.WriteSync()
+}
+
+// evidenceHeight parses the height out of an evidence key: <prefix><height as %016X>/<hash as %X>
+func evidenceHeight(key []byte) (int64, error) {
+	if len(key) < 18 || key[17] != '/' {
+		return 0, fmt.Errorf("invalid evidence key %X", key)
+	}
+
+	return strconv.ParseInt(string(key[1:17]), 16, 64)
+}
@@ -0,0 +1,171 @@
+package pruner
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tendermint/tendermint/crypto/ed25519"
+	"github.com/tendermint/tendermint/state"
+	tmstore "github.com/tendermint/tendermint/store"
+	tmtypes "github.com/tendermint/tendermint/types"
+	db "github.com/tendermint/tm-db"
+)
+
+// saveEvidence saves committed evidence at the heights and pending evidence at the times by height
+// into evidenceDB the way the evidence pool does, and returns their keys by height
+func saveEvidence(
+	t *testing.T, evidenceDB db.DB, committed []int64, pending map[int64]time.Time,
+) (map[int64][]byte, map[int64][]byte) {
+	committedKeys := make(map[int64][]byte)
+	for _, height := range committed {
+		ev := tmtypes.NewMockDuplicateVoteEvidence(height, time.Now(), "chain")
+		key := []byte(fmt.Sprintf("%c%0.16X/%X", evidenceKeyCommitted, height, ev.Hash()))
+		require.NoError(t, evidenceDB.Set(key, []byte{0x1}))
+		committedKeys[height] = key
+	}
+
+	pendingKeys := make(map[int64][]byte)
+	for height, evTime := range pending {
+		ev := tmtypes.NewMockDuplicateVoteEvidence(height, evTime, "chain")
+		evpb, err := tmtypes.EvidenceToProto(ev)
+		require.NoError(t, err)
+		bz, err := evpb.Marshal()
+		require.NoError(t, err)
+		key := []byte(fmt.Sprintf("%c%0.16X/%X", evidenceKeyPending, height, ev.Hash()))
+		require.NoError(t, evidenceDB.Set(key, bz))
+		pendingKeys[height] = key
+	}
+
+	return committedKeys, pendingKeys
+}
+
+func TestPruneEvidence(t *testing.T) {
+	now := time.Now()
+
+	var st state.State
+	st.LastBlockHeight = 1000
+	st.LastBlockTime = now
+	st.ConsensusParams.Evidence.MaxAgeNumBlocks = 100
+	st.ConsensusParams.Evidence.MaxAgeDuration = time.Hour
+
+	// without blocks the committed evidence is as old as the pruned blocks
+	blockStore := tmstore.NewBlockStore(db.NewMemDB())
+
+	evidenceDB := db.NewMemDB()
+	committed, pending := saveEvidence(t, evidenceDB, []int64{100, 899, 900, 949, 950, 960}, map[int64]time.Time{
+		100: now.Add(-2 * time.Hour),
+		// not older than MaxAgeDuration
+		200: now.Add(-10 * time.Minute),
+		960: now.Add(-2 * time.Hour),
+	})
+
+	// nothing is deleted once ctx is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p, c, err := pruneEvidence(ctx, evidenceDB, blockStore, st, 950)
+	require.NoError(t, err)
+	require.Zero(t, p)
+	require.Zero(t, c)
+
+	p, c, err = pruneEvidence(context.Background(), evidenceDB, blockStore, st, 950)
+	require.NoError(t, err)
+	require.Equal(t, 1, p)
+	require.Equal(t, 2, c)
+
+	for height, kept := range map[int64]bool{100: false, 899: false, 900: true, 949: true, 950: true, 960: true} {
+		has, err := evidenceDB.Has(committed[height])
+		require.NoError(t, err)
+		require.Equal(t, kept, has, "committed evidence at height %d", height)
+	}
+	for height, kept := range map[int64]bool{100: false, 200: true, 960: true} {
+		has, err := evidenceDB.Has(pending[height])
+		require.NoError(t, err)
+		require.Equal(t, kept, has, "pending evidence at height %d", height)
+	}
+}
+
+// saveStates saves the states of the heights 1 to height into the state store of dataDir
+func saveStates(t *testing.T, dataDir string, height int64) {
+	stateDB, err := db.NewGoLevelDB("state", dataDir)
+	require.NoError(t, err)
+	defer stateDB.Close()
+	stateStore := state.NewStore(stateDB)
+
+	val := tmtypes.NewValidator(ed25519.GenPrivKey().PubKey(), 10)
+	st, err := state.MakeGenesisState(&tmtypes.GenesisDoc{
+		ChainID:         "chain",
+		GenesisTime:     time.Now(),
+		Validators:      []tmtypes.GenesisValidator{{Address: val.Address, PubKey: val.PubKey, Power: 10}},
+		ConsensusParams: tmtypes.DefaultConsensusParams(),
+	})
+	require.NoError(t, err)
+	require.NoError(t, stateStore.Save(st))
+
+	for h := int64(1); h <= height; h++ {
+		st.LastBlockHeight = h
+		st.LastValidators = st.Validators
+		require.NoError(t, stateStore.Save(st))
+	}
+}
+
+func TestPruneTendermintAroundUpgrade(t *testing.T) {
+	home := t.TempDir()
+	dataDir := filepath.Join(home, "data")
+	saveBlocks(t, dataDir, 30)
+	saveStates(t, dataDir, 30)
+
+	// the blocks and states of an upgrade at 15 are kept 5 heights around it
+	ranges := []HeightRange{{1, 9}, {21, 24}}
+	p, err := New(Options{
+		Home:       home,
+		Tendermint: true,
+		Plan: &Plan{
+			Format:     PlanFormat,
+			Tendermint: &TendermintPlan{PruneHeight: 25, Blocks: ranges, States: ranges},
+			Compact:    []string{},
+		},
+	})
+	require.NoError(t, err)
+
+	result, err := p.PruneTendermint(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(25), result.BlockHeight)
+	require.Equal(t, int64(25), result.StateHeight)
+
+	// pruning again skips the blocks deleted already
+	result, err = p.PruneTendermint(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(25), result.BlockHeight)
+
+	blockStoreDB, err := db.NewGoLevelDB("blockstore", dataDir)
+	require.NoError(t, err)
+	blockStore := tmstore.NewBlockStore(blockStoreDB)
+	defer blockStore.Close()
+	stateDB, err := db.NewGoLevelDB("state", dataDir)
+	require.NoError(t, err)
+	defer stateDB.Close()
+	stateStore := state.NewStore(stateDB)
+
+	// the base moves up to the upgrade, the blocks above it are deleted without moving it
+	require.Equal(t, int64(10), blockStore.Base())
+	require.Equal(t, int64(30), blockStore.Height())
+	for h := int64(10); h <= 30; h++ {
+		kept := !inHeightRanges(ranges, h)
+		require.Equal(t, kept, blockStore.LoadBlockMeta(h) != nil, "block %d", h)
+		require.Equal(t, kept, blockStore.LoadBlockPart(h, 0) != nil, "block %d", h)
+		has, err := blockStoreDB.Has([]byte(fmt.Sprintf("SC:%d", h)))
+		require.NoError(t, err)
+		require.Equal(t, kept, has, "block %d", h)
+
+		if kept {
+			_, err := stateStore.LoadValidators(h)
+			require.NoError(t, err, "state %d", h)
+			_, err = stateStore.LoadConsensusParams(h)
+			require.NoError(t, err, "state %d", h)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package pruner
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	upgradetypes "github.com/cosmos/cosmos-sdk/x/upgrade/types"
+	"github.com/cosmos/iavl"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	db "github.com/tendermint/tm-db"
+)
+
+// Upgrade is a completed upgrade plan of the x/upgrade module
+type Upgrade struct {
+	Name   string `json:"name"`
+	Height int64  `json:"height"`
+}
+
+// Upgrades reads the completed upgrade plans from the done markers of the upgrade store at its
+// latest version, sorted by height. With ProtectUpgrades set, the pruner keeps them from then on.
+// PruneApp, PruneTendermint, Plan and Status read them by themselves, but PruneTendermint can not
+// while PruneApp holds the application db, so call Upgrades first when running both at once.
+func (p *Pruner) Upgrades(ctx context.Context) ([]Upgrade, error) {
+	o := opt.Options{
+		DisableSeeksCompaction: true,
+		ReadOnly:               true,
+	}
+
+	appDB, err := db.NewGoLevelDBWithOpts("application", p.dbDir(), &o)
+	if err != nil {
+		return nil, err
+	}
+	defer appDB.Close()
+
+	return p.loadUpgrades(appDB)
+}
+
+// loadUpgrades reads the completed upgrade plans from appDB and records them
+func (p *Pruner) loadUpgrades(appDB db.DB) ([]Upgrade, error) {
+	upgrades, err := readUpgrades(db.NewPrefixDB(appDB, []byte("s/k:"+upgradetypes.StoreKey+"/")))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upgrades: %w", err)
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.upgrades = upgrades
+
+	return upgrades, nil
+}
+
+// readUpgrades reads the done markers <DoneByte><name> -> <height as big endian uint64> of the
+// latest version of an upgrade store
+func readUpgrades(storeDB db.DB) ([]Upgrade, error) {
+	upgrades := make([]Upgrade, 0)
+
+	tree, err := iavl.NewMutableTree(storeDB, 0)
+	if err != nil {
+		return nil, err
+	}
+	version, err := tree.LazyLoadVersion(0)
+	if err != nil {
+		return nil, err
+	}
+	if version == 0 {
+		return upgrades, nil
+	}
+
+	var parseErr error
+	start, end := []byte{upgradetypes.DoneByte}, []byte{upgradetypes.DoneByte + 1}
+	tree.IterateRange(start, end, true, func(key, value []byte) bool {
+		if len(value) != 8 {
+			parseErr = fmt.Errorf("invalid height of upgrade %s: %X", key[1:], value)
+			return true
+		}
+		upgrades = append(upgrades, Upgrade{Name: string(key[1:]), Height: int64(binary.BigEndian.Uint64(value))})
+		return false
+	})
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	sort.SliceStable(upgrades, func(i, j int) bool {
+		return upgrades[i].Height < upgrades[j].Height
+	})
+
+	return upgrades, nil
+}
+
+// upgradeHeights returns the sorted ranges of heights kept around the recorded upgrades, none
+// unless ProtectUpgrades is set
+func (p *Pruner) upgradeHeights() []HeightRange {
+	if !p.opts.ProtectUpgrades {
+		return nil
+	}
+
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	ranges := make([]HeightRange, 0, len(p.upgrades))
+	window := int64(p.opts.UpgradeWindow)
+	for _, u := range p.upgrades {
+		r := HeightRange{From: u.Height - window, To: u.Height + window}
+		if r.From < 1 {
+			r.From = 1
+		}
+		ranges = append(ranges, r)
+	}
+
+	return mergeHeightRanges(ranges)
+}
+
+// upgradesLoaded returns whether the upgrades were read already
+func (p *Pruner) upgradesLoaded() bool {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	return p.upgrades != nil
+}
+
+// BlockPruneHeight returns the height the blocks from base up to height are pruned below, keeping
+// the latest KeepBlocks blocks. No blocks are pruned when it is base or lower, as with KeepBlocks 0.
+func (p *Pruner) BlockPruneHeight(base, height int64) int64 {
+	if p.opts.KeepBlocks == 0 {
+		return base
+	}
+
+	return height - int64(p.opts.KeepBlocks)
+}
+
+// BlockPruneRanges returns the sorted ranges of heights whose blocks and states are pruned from
+// base up to height. They are the heights below BlockPruneHeight, except for the upgrade heights
+// read by Upgrades, which split them.
+func (p *Pruner) BlockPruneRanges(base, height int64) []HeightRange {
+	pruneHeight := p.BlockPruneHeight(base, height)
+
+	ranges := make([]HeightRange, 0)
+	from := base
+	for _, r := range p.upgradeHeights() {
+		if r.From >= pruneHeight {
+			break
+		}
+		if r.To < from {
+			continue
+		}
+		if r.From > from {
+			ranges = append(ranges, HeightRange{From: from, To: r.From - 1})
+		}
+		from = r.To + 1
+	}
+	if from < pruneHeight {
+		ranges = append(ranges, HeightRange{From: from, To: pruneHeight - 1})
+	}
+
+	return ranges
+}
@@ -0,0 +1,71 @@
+package pruner
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/cosmos/iavl"
+	"github.com/stretchr/testify/require"
+	db "github.com/tendermint/tm-db"
+)
+
+func TestReadUpgrades(t *testing.T) {
+	storeDB := db.NewMemDB()
+
+	upgrades, err := readUpgrades(storeDB)
+	require.NoError(t, err)
+	require.Empty(t, upgrades)
+
+	tree, err := iavl.NewMutableTree(storeDB, 0)
+	require.NoError(t, err)
+	for name, height := range map[string]uint64{"v3": 5000, "v2": 1200} {
+		bz := make([]byte, 8)
+		binary.BigEndian.PutUint64(bz, height)
+		tree.Set(append([]byte{0x1}, name...), bz)
+	}
+	// the current plan and other records are not done markers
+	tree.Set([]byte{0x0}, []byte("plan"))
+	tree.Set([]byte{0x2}, []byte("version map"))
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+
+	upgrades, err = readUpgrades(storeDB)
+	require.NoError(t, err)
+	require.Equal(t, []Upgrade{{"v2", 1200}, {"v3", 5000}}, upgrades)
+}
+
+func TestProtectUpgrades(t *testing.T) {
+	versions := []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	upgrades := []Upgrade{{"v2", 3}, {"v3", 8}}
+
+	p, err := New(Options{Home: "/node", KeepBlocks: 2, ProtectUpgrades: true, UpgradeWindow: 1})
+	require.NoError(t, err)
+	p.upgrades = upgrades
+	require.Equal(t, []int64{1, 5, 6, 10}, p.PruneHeights("acc", versions))
+	require.Equal(t, int64(18), p.BlockPruneHeight(1, 20))
+	require.Equal(t, []HeightRange{{1, 1}, {5, 6}, {10, 17}}, p.BlockPruneRanges(1, 20))
+	require.Equal(t, []HeightRange{{5, 6}, {10, 17}}, p.BlockPruneRanges(3, 20))
+	require.Equal(t, []HeightRange{{5, 6}}, p.BlockPruneRanges(5, 9))
+	require.Equal(t, []HeightRange{{10, 17}}, p.BlockPruneRanges(10, 20))
+	require.Empty(t, p.BlockPruneRanges(7, 9))
+
+	// an upgrade far below the latest height only keeps the blocks around it
+	p, err = New(Options{Home: "/node", KeepBlocks: MinKeepBlocks, ProtectUpgrades: true, UpgradeWindow: 10})
+	require.NoError(t, err)
+	p.upgrades = []Upgrade{{"v2", 100}}
+	require.Equal(t, int64(900000), p.BlockPruneHeight(1, 1000000))
+	require.Equal(t, []HeightRange{{1, 89}, {111, 899999}}, p.BlockPruneRanges(1, 1000000))
+	require.Equal(t, []HeightRange{{111, 899999}}, p.BlockPruneRanges(90, 1000000))
+
+	p, err = New(Options{Home: "/node", KeepBlocks: 2})
+	require.NoError(t, err)
+	p.upgrades = upgrades
+	require.Equal(t, versions, p.PruneHeights("acc", versions))
+	require.Equal(t, int64(18), p.BlockPruneHeight(1, 20))
+	require.Equal(t, []HeightRange{{1, 17}}, p.BlockPruneRanges(1, 20))
+
+	p, err = New(Options{Home: "/node"})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), p.BlockPruneHeight(1, 20))
+	require.Empty(t, p.BlockPruneRanges(1, 20))
+}
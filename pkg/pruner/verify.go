@@ -0,0 +1,250 @@
+package pruner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cosmos/cosmos-sdk/store/types"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	tmstore "github.com/tendermint/tendermint/store"
+	db "github.com/tendermint/tm-db"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+)
+
+// SnapshotVerification is the outcome of VerifySnapshot
+type SnapshotVerification struct {
+	Snapshot Snapshot
+	// Hash is the commit info hash of the state restored from the snapshot
+	Hash []byte
+	// CommitInfoHash is the hash of the commit info at the snapshot height in the application db,
+	// AppHash the app hash of the next block in the block store, nil if they are not there
+	CommitInfoHash []byte
+	AppHash        []byte
+}
+
+// SnapshotMismatchError is returned by VerifySnapshot when the restored state differs from the
+// state of the node at the snapshot height
+type SnapshotMismatchError struct {
+	Height int64
+	Hash   []byte
+	// Source is what the restored state was compared to, and Expected its hash
+	Source   string
+	Expected []byte
+	// Stores are the stores whose restored hash differs from the commit info, if it is there
+	Stores []string
+}
+
+func (e *SnapshotMismatchError) Error() string {
+	msg := fmt.Sprintf("state restored from snapshot at height %d has hash %X, but the %s is %X",
+		e.Height, e.Hash, e.Source, e.Expected)
+	if len(e.Stores) > 0 {
+		msg += fmt.Sprintf(", stores %s differ", strings.Join(e.Stores, ", "))
+	}
+
+	return msg
+}
+
+// VerifySnapshot checks the chunks of a local snapshot, restores it into a memory db, or into a
+// temporary db in tmpDir if set, and compares the commit info hash of the restored state to the
+// commit info at the snapshot height in the application db and to the app hash of the next block
+// in the block store. The stores are mounted as in the commit info at the snapshot height, or at
+// the latest version if it was deleted. It returns a *SnapshotMismatchError if a hash differs,
+// and an error if there is neither to compare to.
+func (p *Pruner) VerifySnapshot(ctx context.Context, s Snapshot, tmpDir string) (*SnapshotVerification, error) {
+	if err := p.CheckSnapshot(ctx, s); err != nil {
+		return nil, err
+	}
+
+	o := opt.Options{
+		DisableSeeksCompaction: true,
+		ReadOnly:               true,
+	}
+
+	appDB, err := db.NewGoLevelDBWithOpts("application", p.dbDir(), &o)
+	if err != nil {
+		return nil, err
+	}
+	defer appDB.Close()
+
+	result := &SnapshotVerification{Snapshot: s}
+
+	cInfo, err := rootmulti.GetCommitInfo(appDB, s.Height)
+	if err == nil {
+		result.CommitInfoHash = cInfo.Hash()
+	} else {
+		latest, err := rootmulti.GetLatestVersion(appDB)
+		if err != nil {
+			return nil, err
+		}
+		if cInfo, err = rootmulti.GetCommitInfo(appDB, latest); err != nil {
+			return nil, fmt.Errorf("failed to read the stores to restore: %w", err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(p.dbDir(), "blockstore.db")); err == nil {
+		blockStoreDB, err := db.NewGoLevelDBWithOpts("blockstore", p.dbDir(), &o)
+		if err != nil {
+			return nil, err
+		}
+		blockStore := tmstore.NewBlockStore(blockStoreDB)
+		// the app hash of a block is the state after the previous one
+		if meta := blockStore.LoadBlockMeta(s.Height + 1); meta != nil {
+			result.AppHash = meta.Header.AppHash
+		}
+		blockStore.Close()
+	}
+
+	if result.CommitInfoHash == nil && result.AppHash == nil {
+		return nil, fmt.Errorf("neither the commit info at height %d nor block %d are there to verify the snapshot against",
+			s.Height, s.Height+1)
+	}
+
+	restoreDB, err := p.restoreDB(tmpDir)
+	if err != nil {
+		return nil, err
+	}
+	defer restoreDB.Close()
+
+	restored, err := p.restoreSnapshot(ctx, restoreDB, s, cInfo)
+	if err != nil {
+		return result, err
+	}
+	result.Hash = restored.Hash()
+
+	if result.CommitInfoHash != nil && !bytes.Equal(result.Hash, result.CommitInfoHash) {
+		return result, &SnapshotMismatchError{
+			Height:   s.Height,
+			Hash:     result.Hash,
+			Source:   "commit info hash",
+			Expected: result.CommitInfoHash,
+			Stores:   differentStores(restored, cInfo),
+		}
+	}
+	if result.AppHash != nil && !bytes.Equal(result.Hash, result.AppHash) {
+		return result, &SnapshotMismatchError{
+			Height:   s.Height,
+			Hash:     result.Hash,
+			Source:   fmt.Sprintf("app hash of block %d", s.Height+1),
+			Expected: result.AppHash,
+		}
+	}
+
+	return result, nil
+}
+
+// restoreDB returns a memory db, or a db in a new temporary directory in tmpDir that is removed
+// when it is closed
+func (p *Pruner) restoreDB(tmpDir string) (db.DB, error) {
+	if tmpDir == "" {
+		return db.NewMemDB(), nil
+	}
+
+	dir, err := os.MkdirTemp(tmpDir, "snapshot-")
+	if err != nil {
+		return nil, err
+	}
+	restoreDB, err := db.NewGoLevelDB("application", dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	return &tempDB{GoLevelDB: restoreDB, dir: dir}, nil
+}
+
+// tempDB is a db removed from disk when it is closed
+type tempDB struct {
+	*db.GoLevelDB
+	dir string
+}
+
+func (t *tempDB) Close() error {
+	if err := t.GoLevelDB.Close(); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(t.dir)
+}
+
+// restoreSnapshot restores the chunks of a snapshot into the stores of cInfo in restoreDB, the
+// stores without a commit id as memory stores, and returns the commit info of the restored state.
+// It stops reading chunks once ctx is done.
+func (p *Pruner) restoreSnapshot(
+	ctx context.Context, restoreDB db.DB, s Snapshot, cInfo *types.CommitInfo,
+) (*types.CommitInfo, error) {
+	restoreStore := rootmulti.NewStore(restoreDB)
+	for _, info := range cInfo.StoreInfos {
+		if info.CommitId.IsZero() {
+			restoreStore.MountStoreWithDB(types.NewMemoryStoreKey(info.Name), types.StoreTypeMemory, nil)
+		} else {
+			restoreStore.MountStoreWithDB(types.NewKVStoreKey(info.Name), types.StoreTypeIAVL, nil)
+		}
+	}
+	if err := restoreStore.LoadLatestVersion(); err != nil {
+		return nil, err
+	}
+
+	chunks := make(chan io.ReadCloser)
+	chunkErr := make(chan error, 1)
+	go func() {
+		defer close(chunks)
+		for i := uint32(0); i < s.Chunks; i++ {
+			file, err := os.Open(filepath.Join(p.SnapshotPath(s), strconv.FormatUint(uint64(i), 10)))
+			if err != nil {
+				chunkErr <- err
+				return
+			}
+			select {
+			case chunks <- file:
+			case <-ctx.Done():
+				file.Close()
+				return
+			}
+		}
+	}()
+
+	err := restoreStore.Restore(uint64(s.Height), s.Format, chunks, nil)
+	// drain the chunks the restore stopped reading early
+	for file := range chunks {
+		file.Close()
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	select {
+	case err := <-chunkErr:
+		return nil, fmt.Errorf("failed to read chunk: %w", err)
+	default:
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore snapshot: %w", err)
+	}
+
+	return rootmulti.GetCommitInfo(restoreDB, s.Height)
+}
+
+// differentStores returns the sorted names of the stores whose commit ids differ between two commit infos
+func differentStores(a, b *types.CommitInfo) []string {
+	ids := make(map[string]types.CommitID, len(a.StoreInfos))
+	for _, info := range a.StoreInfos {
+		ids[info.Name] = info.CommitId
+	}
+
+	names := make([]string, 0)
+	for _, info := range b.StoreInfos {
+		if id, ok := ids[info.Name]; !ok || !bytes.Equal(id.Hash, info.CommitId.Hash) {
+			names = append(names, info.Name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,79 @@
+package pruner
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cosmos/cosmos-sdk/snapshots"
+	"github.com/cosmos/cosmos-sdk/store/types"
+	"github.com/stretchr/testify/require"
+	db "github.com/tendermint/tm-db"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+)
+
+// saveAppSnapshot commits versions of an application state with a value per version that
+// differs by salt, and saves a snapshot of height into the snapshot store of home
+func saveAppSnapshot(t *testing.T, dataDir, home string, versions int, height uint64, salt string) {
+	appDB, err := db.NewGoLevelDB("application", dataDir)
+	require.NoError(t, err)
+	defer appDB.Close()
+
+	appStore := rootmulti.NewStore(appDB)
+	keys := []types.StoreKey{types.NewKVStoreKey("acc"), types.NewKVStoreKey("bank")}
+	for _, key := range keys {
+		appStore.MountStoreWithDB(key, types.StoreTypeIAVL, nil)
+	}
+	appStore.MountStoreWithDB(types.NewMemoryStoreKey("memory"), types.StoreTypeMemory, nil)
+	require.NoError(t, appStore.LoadLatestVersion())
+	for v := 1; v <= versions; v++ {
+		appStore.GetCommitKVStore(keys[0]).Set([]byte{byte(v)}, []byte(salt))
+		appStore.GetCommitKVStore(keys[1]).Set([]byte{byte(v)}, []byte("bank"))
+		appStore.Commit()
+	}
+
+	dir := filepath.Join(home, "data", "snapshots")
+	metadataDB, err := db.NewGoLevelDB("metadata", dir)
+	require.NoError(t, err)
+	defer metadataDB.Close()
+	store, err := snapshots.NewStore(metadataDB, dir)
+	require.NoError(t, err)
+
+	chunks, err := appStore.Snapshot(height, 1)
+	require.NoError(t, err)
+	_, err = store.Save(height, 1, chunks)
+	require.NoError(t, err)
+}
+
+func TestVerifySnapshot(t *testing.T) {
+	home := t.TempDir()
+	saveAppSnapshot(t, filepath.Join(home, "data"), home, 3, 2, "acc")
+	// a snapshot of another state at height 3
+	saveAppSnapshot(t, t.TempDir(), home, 3, 3, "other")
+
+	p, err := New(Options{Home: home})
+	require.NoError(t, err)
+	list, err := p.Snapshots(context.Background())
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+
+	result, err := p.VerifySnapshot(context.Background(), list[0], "")
+	require.NoError(t, err)
+	require.Equal(t, result.CommitInfoHash, result.Hash)
+	require.Nil(t, result.AppHash)
+
+	tmpDir := t.TempDir()
+	_, err = p.VerifySnapshot(context.Background(), list[0], tmpDir)
+	require.NoError(t, err)
+	entries, err := os.ReadDir(tmpDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	_, err = p.VerifySnapshot(context.Background(), list[1], "")
+	var mismatch *SnapshotMismatchError
+	require.True(t, errors.As(err, &mismatch))
+	require.Equal(t, []string{"acc"}, mismatch.Stores)
+}